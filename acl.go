@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+)
+
+// ipList decides which addresses may connect. Deny rules win over allow
+// rules, and an empty allow list allows everybody who is not denied.
+type ipList struct {
+	Allow []string `json:"allow"`
+	Deny  []string `json:"deny"`
+	allow []*net.IPNet
+	deny  []*net.IPNet
+}
+
+var acl struct {
+	sync.RWMutex
+	list *ipList
+}
+
+// loadACL reads the access lists from the JSON file path.
+func loadACL(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	l := &ipList{}
+	if err := json.Unmarshal(data, l); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if l.allow, err = parseNets(l.Allow); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	if l.deny, err = parseNets(l.Deny); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	acl.Lock()
+	defer acl.Unlock()
+	acl.list = l
+	return nil
+}
+
+// parseNets parses CIDRs. A plain IP stands for itself.
+func parseNets(list []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, v := range list {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("bad address %s", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("bad network %s", v)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// containsIP reports whether one of nets contains ip.
+func containsIP(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// permits reports whether ip may connect according to l.
+func (l *ipList) permits(ip net.IP) bool {
+	if containsIP(l.deny, ip) {
+		return false
+	}
+	return len(l.allow) == 0 || containsIP(l.allow, ip)
+}
+
+// accepted reports whether the global access lists let addr connect.
+func accepted(addr net.Addr) bool {
+	acl.RLock()
+	l := acl.list
+	acl.RUnlock()
+	if l == nil {
+		return true
+	}
+	tcp, ok := addr.(*net.TCPAddr)
+	return ok && l.permits(tcp.IP)
+}
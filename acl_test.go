@@ -0,0 +1,37 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestACL(t *testing.T) {
+	path := filepath.Join(testRoot(t), "acl.json")
+	defer func() { acl.list = nil }()
+	addr := func(ip string) net.Addr { return &net.TCPAddr{IP: net.ParseIP(ip), Port: 2121} }
+
+	assert.True(t, accepted(addr("203.0.113.9")))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"allow": ["192.0.2.0/24", "2001:db8::/32", "198.51.100.7"], "deny": ["192.0.2.128/25"]}`), 0644))
+	assert.NoError(t, loadACL(path))
+	assert.True(t, accepted(addr("192.0.2.1")))
+	assert.False(t, accepted(addr("192.0.2.200")))
+	assert.True(t, accepted(addr("198.51.100.7")))
+	assert.False(t, accepted(addr("198.51.100.8")))
+	assert.True(t, accepted(addr("2001:db8::1")))
+	assert.False(t, accepted(addr("203.0.113.9")))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"deny": ["203.0.113.0/24"]}`), 0644))
+	assert.NoError(t, loadACL(path))
+	assert.False(t, accepted(addr("203.0.113.9")))
+	assert.True(t, accepted(addr("192.0.2.200")))
+
+	assert.NoError(t, ioutil.WriteFile(path, []byte(`{"deny": ["203.0.113.0/33"]}`), 0644))
+	assert.EqualError(t, loadACL(path), path+": bad network 203.0.113.0/33")
+	// a broken file keeps the old lists.
+	assert.False(t, accepted(addr("203.0.113.9")))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// listenAdmin opens the listener of the admin API at addr.
+func listenAdmin(addr string) (*net.TCPListener, error) {
+	if option.Users == "" {
+		return nil, errors.New("the admin API needs a users file with admin users")
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenTCP("tcp", tcpAddr)
+}
+
+// serveAdmin serves the admin API until listener is closed.
+func serveAdmin(listener *net.TCPListener) {
+	server := &http.Server{
+		Handler: adminAPI{},
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			return context.WithValue(ctx, adminConnKey{}, c)
+		},
+	}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+		fmt.Println(err)
+	}
+}
+
+type adminConnKey struct{}
+
+// adminAPI is the admin JSON API. Admin users of the users file log in with
+// basic authentication on every request:
+//
+//	GET    /sessions             the connected sessions, see SessionInfo
+//	DELETE /sessions/id          kick a session
+//	GET    /bans                 the banned IPs
+//	POST   /users/name/disable   disable a user and kick its sessions
+//	POST   /reload               read the users file and access lists again
+type adminAPI struct{}
+
+func (a adminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, _ := r.Context().Value(adminConnKey{}).(*net.TCPConn)
+	if conn == nil || !accepted(conn.RemoteAddr()) {
+		adminError(w, http.StatusForbidden, "forbidden")
+		return
+	}
+	s, status, err := adminLogin(conn, r)
+	if err != nil {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Basic realm="goftp admin", charset="UTF-8"`)
+		}
+		adminError(w, status, err.Error())
+		return
+	}
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case r.Method == http.MethodGet && path == "sessions":
+		list := []SessionInfo{}
+		for _, v := range sessions.Sessions() {
+			list = append(list, v.Info())
+		}
+		adminJSON(w, list)
+	case r.Method == http.MethodDelete && len(parts) == 2 && parts[0] == "sessions":
+		id, err := strconv.ParseUint(parts[1], 10, 64)
+		target := sessions.Get(id)
+		if err != nil || target == nil {
+			adminError(w, http.StatusNotFound, "no session "+parts[1])
+			return
+		}
+		audit(s, "kick", parts[1], target.Info().User)
+		target.Kick()
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet && path == "bans":
+		list := guard.Bans()
+		if list == nil {
+			list = []Ban{}
+		}
+		adminJSON(w, list)
+	case r.Method == http.MethodPost && len(parts) == 3 && parts[0] == "users" && parts[2] == "disable":
+		if err := disableUser(parts[1]); err != nil {
+			adminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		audit(s, "disable", parts[1])
+		for _, v := range sessions.Sessions() {
+			if v.Info().User == parts[1] {
+				v.Kick()
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPost && path == "reload":
+		if err := reload(s); err != nil {
+			adminError(w, http.StatusInternalServerError, strings.TrimSpace(err.Error()))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		adminError(w, http.StatusNotFound, "unknown request "+r.Method+" "+r.URL.Path)
+	}
+}
+
+// adminLogin authenticates the request r of an admin like pass does. The
+// session it returns only names the admin in the audit log. It returns the
+// HTTP status of a failure.
+func adminLogin(conn *net.TCPConn, r *http.Request) (*Session, int, error) {
+	s := &Session{Protocol: "admin", Conn: conn}
+	if err := notBanned(s); err != nil {
+		return nil, http.StatusForbidden, trimError(err)
+	}
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, http.StatusUnauthorized, errors.New("login required")
+	}
+	u, err := checkPassword(s, name, password)
+	if err != nil {
+		return nil, http.StatusUnauthorized, trimError(err)
+	}
+	if !u.Admin {
+		audit(s, "login-denied", name)
+		return nil, http.StatusForbidden, errors.New("permission denied")
+	}
+	s.User, s.account = name, u
+	return s, 0, nil
+}
+
+// adminJSON writes v as the answer of a request.
+func adminJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// adminError answers a request with status and the message {"error": msg}.
+func adminError(w http.ResponseWriter, status int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminAPI(t *testing.T) {
+	saved, oldRoot := *option, Root
+	defer func() { *option, Root = saved, oldRoot }()
+	option.LoginDelay = 0
+	Root = testRoot(t, "bob/big.iso")
+	dir := testRoot(t)
+	assert.NoError(t, testUsers(t, dir, `[
+		{"name": "alice", "password": "HASH", "admin": true},
+		{"name": "bob", "password": "HASH", "root": "bob"}
+	]`))
+	option.Users = filepath.Join(dir, "users.json")
+	assert.NoError(t, openAudit(filepath.Join(dir, "audit.log")))
+	defer func() {
+		auditLog.Lock()
+		auditLog.f.Close()
+		auditLog.f = nil
+		auditLog.Unlock()
+	}()
+
+	// bob is connected and downloading
+	clients, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	defer clients.Close()
+	client, err := net.Dial("tcp", clients.Addr().String())
+	assert.NoError(t, err)
+	defer client.Close()
+	conn, err := clients.AcceptTCP()
+	assert.NoError(t, err)
+	s := newSession(conn)
+	assert.NoError(t, enter(s, "bob", lookupUser("bob")))
+	assert.NoError(t, cd(s, []string{"cd", "/"}))
+	p := s.startTransfer(filepath.Join(Root, "bob/big.iso"), false, 100)
+	p.add(40)
+	sessions.add(s)
+	defer sessions.remove(s)
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	defer listener.Close()
+	go serveAdmin(listener)
+	do := func(method, path, user string, v interface{}) int {
+		req, err := http.NewRequest(method, "http://"+listener.Addr().String()+path, nil)
+		assert.NoError(t, err)
+		if user != "" {
+			req.SetBasicAuth(user, "secret")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer resp.Body.Close()
+		if v != nil {
+			assert.NoError(t, json.NewDecoder(resp.Body).Decode(v))
+		}
+		return resp.StatusCode
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, do("GET", "/sessions", "", nil))
+	assert.Equal(t, http.StatusForbidden, do("GET", "/sessions", "bob", nil), "bob is no admin")
+
+	var list []SessionInfo
+	assert.Equal(t, http.StatusOK, do("GET", "/sessions", "alice", &list))
+	assert.Len(t, list, 1)
+	info := list[0]
+	assert.Equal(t, s.ID, info.ID)
+	assert.Equal(t, "bob", info.User)
+	assert.Equal(t, "ftp", info.Protocol)
+	assert.Equal(t, "/", info.Dir)
+	assert.Equal(t, client.LocalAddr().String(), info.Addr)
+	if assert.NotNil(t, info.Transfer) {
+		assert.Equal(t, "/big.iso", info.Transfer.Path)
+		assert.Equal(t, "download", info.Transfer.Direction)
+		assert.Equal(t, int64(100), info.Transfer.Size)
+		assert.Equal(t, int64(40), info.Transfer.Done)
+	}
+	var bans []Ban
+	assert.Equal(t, http.StatusOK, do("GET", "/bans", "alice", &bans))
+	assert.Empty(t, bans)
+
+	// disabling bob kicks his session and survives a reload
+	assert.Equal(t, http.StatusNotFound, do("DELETE", "/sessions/999999", "alice", nil))
+	assert.Equal(t, http.StatusBadRequest, do("POST", "/users/carol/disable", "alice", nil))
+	assert.Equal(t, http.StatusNoContent, do("POST", "/users/bob/disable", "alice", nil))
+	_, err = ioutil.ReadAll(client)
+	assert.NoError(t, err, "the connection of bob is closed")
+	assert.Equal(t, http.StatusNoContent, do("POST", "/reload", "alice", nil))
+	assert.True(t, lookupUser("bob").Disabled)
+	log, err := ioutil.ReadFile(filepath.Join(dir, "audit.log"))
+	assert.NoError(t, err)
+	assert.Contains(t, string(log), `"event":"disable"`)
+	assert.Equal(t, 1, strings.Count(string(log), `"event":"reload"`), "a reload is audited once")
+	assert.Regexp(t, `"user":"alice"[^\n]*"event":"reload"|"event":"reload"[^\n]*"user":"alice"`, string(log))
+	assert.NotNil(t, lookupUser("alice"))
+	assert.Equal(t, http.StatusNoContent, do("DELETE", "/sessions/"+strconv.FormatUint(s.ID, 10), "alice", nil))
+}
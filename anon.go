@@ -0,0 +1,133 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// isAnonymousName reports whether name logs in anonymously.
+func isAnonymousName(name string) bool {
+	return option.Anonymous && (name == "anonymous" || name == "ftp")
+}
+
+// loginAnonymous logs s in to the public area. Any password is taken, by
+// tradition it is the mail address of the client and goes to the audit log.
+func loginAnonymous(s *Session, password string) error {
+	root := filepath.Join(Root, option.AnonRoot)
+	if fi, err := storage.Stat(root); err != nil || !fi.IsDir() {
+		return errors.New("anonymous area is missing\n")
+	}
+	d := &anonDriver{Driver: s.FS, s: s}
+	if option.AnonIncoming != "" {
+		d.incoming = filepath.Join(root, option.AnonIncoming)
+	}
+	fs := s.FS
+	s.mu.Lock()
+	s.User, s.Root, s.Dir, s.FS = "anonymous", root, ".", d
+	s.mu.Unlock()
+	if err := check(func(h Hooks) error { return h.OnLogin(s) }); err != nil {
+		s.mu.Lock()
+		s.User, s.Root, s.FS = "", Root, fs
+		s.mu.Unlock()
+		return err
+	}
+	audit(s, "anonymous-login", password)
+	notify(func(h Hooks) error { return h.OnLogin(s) })
+	return nil
+}
+
+// isAnonymous reports whether s is logged in anonymously.
+func (s *Session) isAnonymous() bool {
+	_, ok := s.FS.(*anonDriver)
+	return ok
+}
+
+// anonDriver is the driver of anonymous sessions. Everything is read-only but
+// the incoming directory, where new files may be uploaded but nothing can be
+// listed, read or replaced. Every refused change is audited.
+type anonDriver struct {
+	Driver
+	s        *Session
+	incoming string
+}
+
+// inIncoming reports whether name lies below the incoming directory.
+func (d *anonDriver) inIncoming(name string) bool {
+	return d.incoming != "" && strings.HasPrefix(name, d.incoming+string(filepath.Separator))
+}
+
+// deny audits the refused operation op on name and returns its error. Paths
+// outside of the session, like its trash, are named by their last element.
+func (d *anonDriver) deny(op, name string) error {
+	path := d.s.clientPath(name)
+	if rel, err := filepath.Rel(d.s.Root, name); err != nil || strings.HasPrefix(rel, "..") {
+		path = filepath.Base(name)
+	}
+	audit(d.s, "anonymous-denied", op, path)
+	return &os.PathError{Op: op, Path: path, Err: os.ErrPermission}
+}
+
+// Stat hides the files of the incoming directory, without an audit: uploads
+// look for the file they write.
+func (d *anonDriver) Stat(name string) (os.FileInfo, error) {
+	if d.inIncoming(name) {
+		return nil, &os.PathError{Op: "stat", Path: d.s.clientPath(name), Err: os.ErrNotExist}
+	}
+	return d.Driver.Stat(name)
+}
+
+func (d *anonDriver) Lstat(name string) (os.FileInfo, error) {
+	if d.inIncoming(name) {
+		return nil, &os.PathError{Op: "lstat", Path: d.s.clientPath(name), Err: os.ErrNotExist}
+	}
+	return d.Driver.Lstat(name)
+}
+
+func (d *anonDriver) ReadDir(name string) ([]os.FileInfo, error) {
+	if name == d.incoming || d.inIncoming(name) {
+		return nil, d.deny("readdir", name)
+	}
+	return d.Driver.ReadDir(name)
+}
+
+func (d *anonDriver) Readlink(name string) (string, error) {
+	if d.inIncoming(name) {
+		return "", d.deny("readlink", name)
+	}
+	return d.Driver.Readlink(name)
+}
+
+func (d *anonDriver) Open(name string) (File, error) {
+	if d.inIncoming(name) {
+		return nil, d.deny("open", name)
+	}
+	return d.Driver.Open(name)
+}
+
+// Create is the only change allowed, in the incoming directory. See
+// Session.commit for the policy of the uploads.
+func (d *anonDriver) Create(name string) (Upload, error) {
+	if !d.inIncoming(name) {
+		return nil, d.deny("create", name)
+	}
+	return d.Driver.Create(name)
+}
+
+// readOnly and fixed hold everywhere: the incoming directory takes new
+// files, but cannot be listed to show facts of them.
+func (d *anonDriver) readOnly(name string) bool { return true }
+func (d *anonDriver) fixed(name string) bool    { return true }
+
+func (d *anonDriver) Mkdir(name string, perm os.FileMode) error { return d.deny("mkdir", name) }
+func (d *anonDriver) Chmod(name string, mode os.FileMode) error { return d.deny("chmod", name) }
+func (d *anonDriver) Symlink(target, name string) error         { return d.deny("symlink", name) }
+func (d *anonDriver) Remove(name string) error                  { return d.deny("remove", name) }
+func (d *anonDriver) Rename(from, to string) error              { return d.deny("rename", from) }
+func (d *anonDriver) Link(from, to string) error                { return d.deny("link", from) }
+
+func (d *anonDriver) Chtimes(name string, atime, mtime time.Time) error {
+	return d.deny("chtimes", name)
+}
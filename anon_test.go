@@ -0,0 +1,52 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnonymous(t *testing.T) {
+	oldRoot, oldAnon := Root, option.Anonymous
+	defer func() { Root, option.Anonymous = oldRoot, oldAnon }()
+	Root = testRoot(t, "private.txt", "pub/release.tgz", "pub/incoming/.keep")
+	s := &Session{Root: Root, Dir: ".", FS: localDriver{}}
+	assert.EqualError(t, loginUser(s, []string{"user", "anonymous"}), "no login required\n", "anonymous logins are off")
+
+	option.Anonymous = true
+	assert.NoError(t, loginUser(s, []string{"user", "ftp"}))
+	assert.NoError(t, loginPass(s, []string{"pass", "me@example.com"}))
+	assert.Equal(t, "anonymous", s.User)
+	assert.True(t, s.isAnonymous())
+
+	// the public tree can be read, nothing outside of it
+	_, err := s.resolve("../private.txt")
+	assert.Error(t, err)
+	pub := filepath.Join(Root, "pub")
+	f, err := s.FS.Open(filepath.Join(pub, "release.tgz"))
+	assert.NoError(t, err)
+	f.Close()
+	_, err = s.FS.Create(filepath.Join(pub, "new.tgz"))
+	assert.True(t, os.IsPermission(err))
+	err = rm(s, []string{"rm", "release.tgz"})
+	assert.Error(t, err)
+	assert.FileExists(t, filepath.Join(pub, "release.tgz"))
+
+	// files can be uploaded to incoming, but neither listed, read nor replaced
+	assert.NoError(t, cp(s, []string{"cp", "incoming/copy.tgz", "release.tgz"}))
+	assert.FileExists(t, filepath.Join(pub, "incoming", "copy.tgz"))
+	assert.EqualError(t, cp(s, []string{"cp", "incoming/copy.tgz", "release.tgz"}), "copy.tgz exists already\n")
+	_, err = s.FS.ReadDir(filepath.Join(pub, "incoming"))
+	assert.True(t, os.IsPermission(err))
+	_, err = s.FS.Open(filepath.Join(pub, "incoming", "copy.tgz"))
+	assert.True(t, os.IsPermission(err))
+	_, err = s.FS.Stat(filepath.Join(pub, "incoming", "copy.tgz"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, cd(s, []string{"cd", "incoming"}))
+	data, err := ioutil.ReadFile(filepath.Join(pub, "incoming", "copy.tgz"))
+	assert.NoError(t, err)
+	assert.Equal(t, "pub/release.tgz", string(data))
+}
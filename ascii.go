@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Transfer types.
+const (
+	TypeASCII  = "A"
+	TypeBinary = "I"
+)
+
+// setType switches the transfer type of the session.
+func setType(s *Session, args []string) (out Buffer) {
+	//type [a|i]
+	if len(args) == 1 {
+		out.Write([]byte("type " + s.Type + "\n"))
+		return
+	}
+	if len(args) != 2 {
+		out.Write([]byte("type [a|i]\n"))
+		return
+	}
+	switch strings.ToUpper(args[1]) {
+	case TypeASCII:
+		s.Type = TypeASCII
+	case TypeBinary:
+		s.Type = TypeBinary
+	default:
+		out.Write([]byte("type [a|i]\n"))
+	}
+	return
+}
+
+// size prints the size of a file as it is transferred with the current type.
+func size(s *Session, args []string) (out Buffer) {
+	//size file
+	if len(args) != 2 {
+		out.Write([]byte("size file\n"))
+		return
+	}
+	path, err := s.resolve(args[1])
+	if err != nil {
+		out.Write([]byte(err.Error()))
+		return
+	}
+	n, err := transferSize(s.FS, path, s.Type)
+	if err != nil {
+		out.Write([]byte(err.Error()))
+		return
+	}
+	out.Write([]byte(fmt.Sprintln(n)))
+	return
+}
+
+// transferSize returns the number of bytes sent for the file path with type typ.
+// In ASCII every bare LF becomes CRLF, so the file has to be read.
+func transferSize(fs Driver, path string, typ string) (int64, error) {
+	fi, err := fs.Stat(path)
+	if err != nil {
+		return 0, errors.New(err.Error() + "\n")
+	}
+	if fi.IsDir() {
+		return 0, errors.New(fi.Name() + " is a directory\n")
+	}
+	if typ != TypeASCII {
+		return fi.Size(), nil
+	}
+	f, err := fs.Open(path)
+	if err != nil {
+		return 0, errors.New(err.Error() + "\n")
+	}
+	defer f.Close()
+	n := fi.Size()
+	r := bufio.NewReader(f)
+	lastCR := false
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, errors.New(err.Error() + "\n")
+		}
+		if b == '\n' && !lastCR {
+			n++
+		}
+		lastCR = b == '\r'
+	}
+}
+
+// crlfReader turns bare LFs into CRLFs while reading, for ASCII downloads.
+type crlfReader struct {
+	r      io.Reader
+	buf    []byte
+	out    []byte
+	conv   []byte
+	lastCR bool
+	err    error
+}
+
+func newCRLFReader(r io.Reader) *crlfReader {
+	return &crlfReader{r: r, buf: make([]byte, 32*1024)}
+}
+
+func (c *crlfReader) Read(p []byte) (int, error) {
+	for len(c.out) == 0 {
+		if c.err != nil {
+			return 0, c.err
+		}
+		var n int
+		n, c.err = c.r.Read(c.buf)
+		c.conv = c.conv[:0]
+		for _, b := range c.buf[:n] {
+			if b == '\n' && !c.lastCR {
+				c.conv = append(c.conv, '\r')
+			}
+			c.conv = append(c.conv, b)
+			c.lastCR = b == '\r'
+		}
+		c.out = c.conv
+	}
+	n := copy(p, c.out)
+	c.out = c.out[n:]
+	return n, nil
+}
+
+// lfWriter turns CRLFs into LFs while writing, for ASCII uploads.
+// Flush must be called at the end since a CR might be held back.
+type lfWriter struct {
+	w         io.Writer
+	buf       []byte
+	pendingCR bool
+}
+
+func newLFWriter(w io.Writer) *lfWriter {
+	return &lfWriter{w: w}
+}
+
+func (l *lfWriter) Write(p []byte) (int, error) {
+	l.buf = l.buf[:0]
+	for _, b := range p {
+		if l.pendingCR {
+			l.pendingCR = false
+			if b != '\n' {
+				l.buf = append(l.buf, '\r')
+			}
+		}
+		if b == '\r' {
+			l.pendingCR = true
+			continue
+		}
+		l.buf = append(l.buf, b)
+	}
+	if _, err := l.w.Write(l.buf); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes a CR which was held back at the end of the data.
+func (l *lfWriter) Flush() error {
+	if !l.pendingCR {
+		return nil
+	}
+	l.pendingCR = false
+	_, err := l.w.Write([]byte{'\r'})
+	return err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCRLFReader(t *testing.T) {
+	for in, want := range map[string]string{
+		"a\nb\n":      "a\r\nb\r\n",
+		"a\r\nb":      "a\r\nb",
+		"\n\n":        "\r\n\r\n",
+		"lone\rcr\n":  "lone\rcr\r\n",
+		"trailing\r":  "trailing\r",
+		"no newlines": "no newlines",
+	} {
+		data, err := ioutil.ReadAll(newCRLFReader(strings.NewReader(in)))
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(data), "%q", in)
+
+		// a CRLF split between two reads stays a CRLF
+		data, err = ioutil.ReadAll(newCRLFReader(iotest.OneByteReader(strings.NewReader(in))))
+		assert.NoError(t, err)
+		assert.Equal(t, want, string(data), "%q one byte at a time", in)
+	}
+}
+
+func TestLFWriter(t *testing.T) {
+	for _, c := range []struct {
+		chunks []string
+		want   string
+	}{
+		{[]string{"a\r\nb\r\n"}, "a\nb\n"},
+		{[]string{"a\r", "\nb"}, "a\nb"},
+		{[]string{"a\r", "b\r", "\r\n"}, "a\rb\r\n"},
+		{[]string{"lone\rcr"}, "lone\rcr"},
+		{[]string{"bare\nlf"}, "bare\nlf"},
+		{[]string{"trailing\r"}, "trailing\r"},
+	} {
+		var buf bytes.Buffer
+		w := newLFWriter(&buf)
+		for _, v := range c.chunks {
+			n, err := w.Write([]byte(v))
+			assert.NoError(t, err)
+			assert.Equal(t, len(v), n)
+		}
+		assert.NoError(t, w.Flush())
+		assert.Equal(t, c.want, buf.String(), "%q", c.chunks)
+	}
+}
+
+func TestTransferSize(t *testing.T) {
+	root := testRoot(t, "dir/x")
+	for name, data := range map[string]string{
+		"plain": "no newlines",
+		"unix":  "a\nb\n",
+		"dos":   "a\r\nb\r\n",
+		"mixed": "a\r\nb\nc\rd\r",
+	} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(root, name), []byte(data), 0644))
+	}
+	for name, want := range map[string]int64{"plain": 11, "unix": 6, "dos": 6, "mixed": 10} {
+		n, err := transferSize(localDriver{}, filepath.Join(root, name), TypeASCII)
+		assert.NoError(t, err)
+		assert.Equal(t, want, n, name)
+		data, err := ioutil.ReadAll(newCRLFReader(strings.NewReader(readFile(t, filepath.Join(root, name)))))
+		assert.NoError(t, err)
+		assert.Equal(t, want, int64(len(data)), "%s: size and download agree", name)
+	}
+	n, err := transferSize(localDriver{}, filepath.Join(root, "unix"), TypeBinary)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), n)
+	_, err = transferSize(localDriver{}, filepath.Join(root, "dir"), TypeASCII)
+	assert.EqualError(t, err, "dir is a directory\n")
+	_, err = transferSize(localDriver{}, filepath.Join(root, "missing"), TypeASCII)
+	assert.Error(t, err)
+}
+
+func readFile(t *testing.T, path string) string {
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	return string(data)
+}
+
+func TestSetType(t *testing.T) {
+	s := &Session{Type: TypeBinary}
+	assert.Equal(t, "type I\n", string(setType(s, []string{TYPE})))
+	assert.Empty(t, setType(s, []string{TYPE, "a"}))
+	assert.Equal(t, TypeASCII, s.Type)
+	assert.Equal(t, "type A\n", string(setType(s, []string{TYPE})))
+	assert.Empty(t, setType(s, []string{TYPE, "I"}))
+	assert.Equal(t, TypeBinary, s.Type)
+	for _, args := range [][]string{{TYPE, "e"}, {TYPE, "ascii"}, {TYPE, "a", "n"}} {
+		assert.Equal(t, "type [a|i]\n", string(setType(s, args)), "%q", args)
+		assert.Equal(t, TypeBinary, s.Type, "%q", args)
+	}
+}
+
+func TestSize(t *testing.T) {
+	root := testRoot(t, "dir/x")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "unix"), []byte("a\nb\n"), 0644))
+	s := &Session{Root: root, Dir: ".", FS: localDriver{}, Type: TypeBinary}
+	assert.Equal(t, "4\n", string(size(s, []string{SIZE, "unix"})))
+	setType(s, []string{TYPE, "a"})
+	assert.Equal(t, "6\n", string(size(s, []string{SIZE, "unix"})))
+	assert.Equal(t, "dir is a directory\n", string(size(s, []string{SIZE, "dir"})))
+	assert.Equal(t, "size file\n", string(size(s, []string{SIZE})))
+	assert.NotEmpty(t, size(s, []string{SIZE, "missing"}))
+	assert.NotEmpty(t, size(s, []string{SIZE, "../outside"}))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Overwrite policies for files which exist already.
+const (
+	OverwriteReplace = "overwrite"
+	OverwriteFail    = "fail"
+	OverwriteRename  = "rename"
+)
+
+// atomicFile is written under a hidden temporary name in the directory of its
+// target and only replaces the target on Commit, so nobody sees half-written files.
+type atomicFile struct {
+	*os.File
+	name string
+	done bool
+}
+
+// createAtomic creates the temporary file for name.
+func createAtomic(name string) (*atomicFile, error) {
+	dir, base := filepath.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	f, err := ioutil.TempFile(dir, "."+base+".*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	// TempFile creates the file private to us, use the usual mode of new files.
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &atomicFile{File: f, name: name}, nil
+}
+
+// Commit flushes the file to disk and moves it into place according to policy.
+// It returns the name the file was stored under.
+func (f *atomicFile) Commit(policy string) (string, error) {
+	if f.done {
+		return "", errors.New("file already committed\n")
+	}
+	f.done = true
+	tmp := f.File.Name()
+	defer os.Remove(tmp)
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return "", errors.New(err.Error() + "\n")
+	}
+	if err := f.Close(); err != nil {
+		return "", errors.New(err.Error() + "\n")
+	}
+	switch policy {
+	case OverwriteFail:
+		// a hard link never replaces an existing file.
+		if err := os.Link(tmp, f.name); err != nil {
+			if os.IsExist(err) {
+				return "", errors.New(filepath.Base(f.name) + " exists already\n")
+			}
+			return "", errors.New(err.Error() + "\n")
+		}
+		return f.name, nil
+	case OverwriteRename:
+		ext := filepath.Ext(f.name)
+		base := strings.TrimSuffix(f.name, ext)
+		name := f.name
+		for i := 1; ; i++ {
+			err := os.Link(tmp, name)
+			if err == nil {
+				return name, nil
+			}
+			if !os.IsExist(err) {
+				return "", errors.New(err.Error() + "\n")
+			}
+			name = base + "." + strconv.Itoa(i) + ext
+		}
+	default:
+		if err := os.Rename(tmp, f.name); err != nil {
+			return "", errors.New(err.Error() + "\n")
+		}
+		return f.name, nil
+	}
+}
+
+// Chtimes sets the times of the file, Commit keeps them.
+func (f *atomicFile) Chtimes(atime, mtime time.Time) error {
+	return os.Chtimes(f.File.Name(), atime, mtime)
+}
+
+// Abort removes the temporary file unless it was committed.
+func (f *atomicFile) Abort() {
+	if f.done {
+		return
+	}
+	f.done = true
+	f.Close()
+	os.Remove(f.File.Name())
+}
+
+// validOverwrite reports whether policy is a known overwrite policy.
+func validOverwrite(policy string) bool {
+	switch policy {
+	case OverwriteReplace, OverwriteFail, OverwriteRename:
+		return true
+	}
+	return false
+}
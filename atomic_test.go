@@ -0,0 +1,104 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/moshuipan/goftp/transfer"
+	"github.com/stretchr/testify/assert"
+)
+
+// testConn returns both ends of a TCP connection on localhost.
+func testConn(t *testing.T) (*net.TCPConn, *net.TCPConn) {
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	defer ln.Close()
+	client, err := net.DialTCP("tcp", nil, ln.Addr().(*net.TCPAddr))
+	assert.NoError(t, err)
+	server, err := ln.AcceptTCP()
+	assert.NoError(t, err)
+	t.Cleanup(func() { client.Close(); server.Close() })
+	return server, client
+}
+
+func TestAtomicCommit(t *testing.T) {
+	root := testRoot(t, "a.txt")
+	write := func(name, data string) *atomicFile {
+		f, err := createAtomic(filepath.Join(root, name))
+		assert.NoError(t, err)
+		_, err = f.WriteString(data)
+		assert.NoError(t, err)
+		return f
+	}
+
+	f := write("a.txt", "replaced")
+	name, err := f.Commit(OverwriteReplace)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "a.txt"), name)
+	assert.Equal(t, "replaced", readFile(t, name))
+	_, err = f.Commit(OverwriteReplace)
+	assert.EqualError(t, err, "file already committed\n")
+
+	_, err = write("a.txt", "refused").Commit(OverwriteFail)
+	assert.EqualError(t, err, "a.txt exists already\n")
+	assert.Equal(t, "replaced", readFile(t, filepath.Join(root, "a.txt")))
+	name, err = write("b.txt", "new").Commit(OverwriteFail)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", readFile(t, name))
+
+	for i, want := range []string{"a.1.txt", "a.2.txt"} {
+		name, err = write("a.txt", want).Commit(OverwriteRename)
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(root, want), name, "upload %d", i)
+		assert.Equal(t, want, readFile(t, name))
+	}
+	assert.Equal(t, "replaced", readFile(t, filepath.Join(root, "a.txt")))
+
+	f = write("c.txt", "aborted")
+	f.Abort()
+	f.Abort()
+	_, err = f.Commit(OverwriteReplace)
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(root, "c.txt"))
+
+	// no temporary files are left behind.
+	tmp, _ := filepath.Glob(filepath.Join(root, ".*.tmp"))
+	assert.Empty(t, tmp)
+}
+
+func TestValidOverwrite(t *testing.T) {
+	for _, policy := range []string{OverwriteReplace, OverwriteFail, OverwriteRename} {
+		assert.True(t, validOverwrite(policy), policy)
+	}
+	for _, policy := range []string{"", "Overwrite", "keep"} {
+		assert.False(t, validOverwrite(policy), policy)
+	}
+}
+
+func TestUploadFailed(t *testing.T) {
+	saved, oldRoot, oldStorage := *option, Root, storage
+	defer func() { *option, Root, storage = saved, oldRoot, oldStorage }()
+	option.Overwrite, option.Verify = OverwriteReplace, false
+	Root = testRoot(t, "in/a.txt")
+	storage = localDriver{}
+
+	server, client := testConn(t)
+	s := newSession(server)
+	done := make(chan error, 1)
+	go func() { done <- upload(s, []string{"ul", "in", "a.txt"}) }()
+	h, err := transfer.ReadHeader(client)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, h.Streams)
+	fw := transfer.NewWriter(client)
+	_, err = fw.Write([]byte("half of the"))
+	assert.NoError(t, err)
+	assert.NoError(t, fw.Abort())
+	assert.Error(t, <-done)
+
+	assert.Equal(t, "in/a.txt", readFile(t, filepath.Join(Root, "in/a.txt")))
+	entries, err := ioutil.ReadDir(filepath.Join(Root, "in"))
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1, "the temporary file is removed")
+}
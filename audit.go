@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// auditRecord is one line of the audit log.
+type auditRecord struct {
+	Time    time.Time `json:"time"`
+	Event   string    `json:"event"`
+	Session uint64    `json:"session,omitempty"`
+	User    string    `json:"user,omitempty"`
+	Addr    string    `json:"addr,omitempty"`
+	Detail  string    `json:"detail,omitempty"`
+}
+
+var auditLog struct {
+	sync.Mutex
+	f *os.File
+}
+
+// openAudit appends the audit log to the file path.
+func openAudit(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	auditLog.f = f
+	return nil
+}
+
+// audit writes event of s with its details to the audit log as a JSON line.
+// s may be nil for events which do not belong to a session.
+func audit(s *Session, event string, detail ...string) {
+	r := auditRecord{Time: time.Now(), Event: event, Detail: strings.Join(detail, " ")}
+	if s != nil {
+		r.Session, r.User = s.ID, s.User
+		if s.Conn != nil {
+			r.Addr = s.Conn.RemoteAddr().String()
+		}
+	}
+	line, err := json.Marshal(&r)
+	if err != nil {
+		return
+	}
+	auditLog.Lock()
+	defer auditLog.Unlock()
+	if auditLog.f == nil {
+		return
+	}
+	if _, err := auditLog.f.Write(append(line, '\n')); err != nil {
+		fmt.Println("audit log error!", err)
+	}
+}
+
+// auditor writes the events of all sessions to the audit log.
+type auditor struct{}
+
+func (auditor) OnLogin(s *Session) error {
+	audit(s, EventLogin)
+	return nil
+}
+
+func (auditor) OnUpload(s *Session, f FileEvent) error {
+	audit(s, EventUpload, f.Path, strconv.FormatInt(f.Size, 10))
+	return nil
+}
+
+func (auditor) OnUploadFailed(s *Session, f FileEvent, err error) {
+	audit(s, EventUploadFailed, f.Path, strings.TrimSuffix(err.Error(), "\n"))
+}
+
+func (auditor) OnDownload(s *Session, f FileEvent) error {
+	audit(s, EventDownload, f.Path, strconv.FormatInt(f.Size, 10))
+	return nil
+}
+
+func (auditor) OnDelete(s *Session, f FileEvent) error {
+	audit(s, EventDelete, f.Path)
+	return nil
+}
+
+func (auditor) OnRename(s *Session, f FileEvent) error {
+	audit(s, EventRename, f.Path, f.To)
+	return nil
+}
+
+func (auditor) OnDisconnect(s *Session) {
+	audit(s, EventDisconnect)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/moshuipan/goftp/transfer"
+)
+
+// verifyHash is the hash used for the trailer of verified transfers.
+const verifyHash = "sha256"
+
+// checksum prints the digest of a file, optionally of a byte range only.
+func checksum(s *Session, args []string) (out Buffer) {
+	//hash [-a algo] [-r start-end] file
+	//xmd5|xsha1|xsha256 file [start [end]]
+	var (
+		algo       string
+		file       string
+		start, end int64 = 0, -1
+		err        error
+	)
+	if args[0] == HASH {
+		algo = verifyHash
+		i := 1
+		for ; i < len(args)-1 && strings.HasPrefix(args[i], "-"); i += 2 {
+			switch args[i] {
+			case "-a":
+				algo = args[i+1]
+			case "-r":
+				start, end, err = parseRange(args[i+1])
+			default:
+				err = errors.New("hash [-a algo] [-r start-end] file\n")
+			}
+			if err != nil {
+				out.Write([]byte(err.Error()))
+				return
+			}
+		}
+		if i != len(args)-1 {
+			out.Write([]byte("hash [-a algo] [-r start-end] file\n"))
+			return
+		}
+		file = args[i]
+	} else {
+		algo = strings.TrimPrefix(args[0], "x")
+		if len(args) < 2 || len(args) > 4 {
+			out.Write([]byte(args[0] + " file [start [end]]\n"))
+			return
+		}
+		file = args[1]
+		if len(args) > 2 {
+			r := args[2] + "-"
+			if len(args) > 3 {
+				r += args[3]
+			}
+			if start, end, err = parseRange(r); err != nil {
+				out.Write([]byte(err.Error()))
+				return
+			}
+		}
+	}
+	path, err := s.resolve(file)
+	if err != nil {
+		out.Write([]byte(err.Error()))
+		return
+	}
+	sum, end, err := fileDigest(s.FS, path, algo, start, end)
+	if err != nil {
+		out.Write([]byte(err.Error()))
+		return
+	}
+	if args[0] == HASH {
+		out.Write([]byte(fmt.Sprintf("%s %d-%d %s %s\n", strings.ToUpper(algo), start, end, hex.EncodeToString(sum), file)))
+	} else {
+		out.Write([]byte(hex.EncodeToString(sum) + "\n"))
+	}
+	return
+}
+
+// parseRange parses "start-end", end is excluded and may be omitted for the end of the file.
+func parseRange(s string) (start, end int64, err error) {
+	i := strings.Index(s, "-")
+	if i < 0 {
+		return 0, 0, errors.New("bad range " + s + "\n")
+	}
+	end = -1
+	if start, err = strconv.ParseInt(s[:i], 10, 64); err != nil || start < 0 {
+		return 0, 0, errors.New("bad range " + s + "\n")
+	}
+	if s[i+1:] != "" {
+		if end, err = strconv.ParseInt(s[i+1:], 10, 64); err != nil || end < start {
+			return 0, 0, errors.New("bad range " + s + "\n")
+		}
+	}
+	return start, end, nil
+}
+
+// fileDigest hashes the bytes [start, end) of name, a negative end means the end
+// of the file. It returns the digest and the real end of the range.
+func fileDigest(fs Driver, name string, algo string, start, end int64) ([]byte, int64, error) {
+	h, err := transfer.NewHash(algo)
+	if err != nil {
+		return nil, 0, errors.New(err.Error() + "\n")
+	}
+	f, err := fs.Open(name)
+	if err != nil {
+		return nil, 0, errors.New(err.Error() + "\n")
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, errors.New(err.Error() + "\n")
+	}
+	if end < 0 || end > fi.Size() {
+		end = fi.Size()
+	}
+	if start > end {
+		return nil, 0, errors.New("range starts after the end of the file\n")
+	}
+	if _, err := io.Copy(h, io.NewSectionReader(f, start, end-start)); err != nil {
+		return nil, 0, errors.New(err.Error() + "\n")
+	}
+	return h.Sum(nil), end, nil
+}
+
+// verify switches the checksum trailer of transfers on or off.
+func verify(s *Session, args []string) (out Buffer) {
+	//verify [on|off]
+	if len(args) == 1 {
+		if s.Verify {
+			out.Write([]byte("verify on\n"))
+		} else {
+			out.Write([]byte("verify off\n"))
+		}
+		return
+	}
+	if len(args) != 2 || (args[1] != "on" && args[1] != "off") {
+		out.Write([]byte("verify [on|off]\n"))
+		return
+	}
+	s.Verify = args[1] == "on"
+	return
+}
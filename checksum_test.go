@@ -0,0 +1,106 @@
+package main
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRange(t *testing.T) {
+	for _, c := range []struct {
+		in         string
+		start, end int64
+		ok         bool
+	}{
+		{"0-10", 0, 10, true},
+		{"5-", 5, -1, true},
+		{"7-7", 7, 7, true},
+		{"", 0, 0, false},
+		{"10", 0, 0, false},
+		{"-10", 0, 0, false},
+		{"-1-5", 0, 0, false},
+		{"5--1", 0, 0, false},
+		{"10-5", 0, 0, false},
+		{"a-b", 0, 0, false},
+		{"0-99999999999999999999", 0, 0, false},
+		{"99999999999999999999-", 0, 0, false},
+	} {
+		start, end, err := parseRange(c.in)
+		if !c.ok {
+			assert.EqualError(t, err, "bad range "+c.in+"\n")
+			continue
+		}
+		assert.NoError(t, err, c.in)
+		assert.Equal(t, [2]int64{c.start, c.end}, [2]int64{start, end}, c.in)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	root := testRoot(t, "dir/data.txt")
+	s := &Session{Root: root, Dir: ".", FS: localDriver{}}
+	data := []byte("dir/data.txt")
+	hexSum := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+	md5Sum := md5.Sum(data[4:8])
+
+	for _, c := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{HASH, "dir/data.txt"}, fmt.Sprintf("SHA256 0-12 %s dir/data.txt\n", hexSum(data))},
+		{[]string{HASH, "-r", "4-", "dir/data.txt"}, fmt.Sprintf("SHA256 4-12 %s dir/data.txt\n", hexSum(data[4:]))},
+		{[]string{HASH, "-a", "md5", "-r", "4-8", "dir/data.txt"}, fmt.Sprintf("MD5 4-8 %s dir/data.txt\n", hex.EncodeToString(md5Sum[:]))},
+		// an end past the file is the end of the file.
+		{[]string{HASH, "-r", "0-100", "dir/data.txt"}, fmt.Sprintf("SHA256 0-12 %s dir/data.txt\n", hexSum(data))},
+		{[]string{XSHA256, "dir/data.txt"}, hexSum(data) + "\n"},
+		{[]string{XMD5, "dir/data.txt", "4", "8"}, hex.EncodeToString(md5Sum[:]) + "\n"},
+		{[]string{XSHA256, "dir/data.txt", "4"}, hexSum(data[4:]) + "\n"},
+
+		{[]string{HASH, "-r", "8-4", "dir/data.txt"}, "bad range 8-4\n"},
+		{[]string{HASH, "-r", "20-", "dir/data.txt"}, "range starts after the end of the file\n"},
+		{[]string{HASH, "-x", "y", "dir/data.txt"}, "hash [-a algo] [-r start-end] file\n"},
+		{[]string{HASH, "-a", "md5"}, "hash [-a algo] [-r start-end] file\n"},
+		{[]string{XSHA1, "dir/data.txt", "-3"}, "bad range -3-\n"},
+		{[]string{XSHA1}, "xsha1 file [start [end]]\n"},
+		{[]string{HASH, "-a", "crc", "dir/data.txt"}, "unknown hash \"crc\"\n"},
+	} {
+		assert.Equal(t, c.want, string(checksum(s, c.args)), "%q", c.args)
+	}
+	assert.Contains(t, string(checksum(s, []string{HASH, "dir"})), "is a directory")
+	assert.NotEmpty(t, string(checksum(s, []string{HASH, "missing"})))
+	assert.NotEmpty(t, string(checksum(s, []string{HASH, "../outside"})))
+}
+
+func TestFileDigest(t *testing.T) {
+	root := testRoot(t, "data.txt")
+	sum, end, err := fileDigest(localDriver{}, root+"/data.txt", "sha256", 0, -1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), end)
+	want := sha256.Sum256([]byte("data.txt"))
+	assert.Equal(t, want[:], sum)
+
+	sum, end, err = fileDigest(localDriver{}, root+"/data.txt", "SHA-256", 8, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), end)
+	want = sha256.Sum256(nil)
+	assert.Equal(t, want[:], sum, "an empty range")
+}
+
+func TestVerify(t *testing.T) {
+	s := &Session{}
+	assert.Equal(t, "verify off\n", string(verify(s, []string{VERIFY})))
+	assert.Empty(t, verify(s, []string{VERIFY, "on"}))
+	assert.True(t, s.Verify)
+	assert.Equal(t, "verify on\n", string(verify(s, []string{VERIFY})))
+	assert.Equal(t, "verify [on|off]\n", string(verify(s, []string{VERIFY, "yes"})))
+	assert.Equal(t, "verify [on|off]\n", string(verify(s, []string{VERIFY, "on", "off"})))
+	assert.True(t, s.Verify)
+	assert.Empty(t, verify(s, []string{VERIFY, "off"}))
+	assert.False(t, s.Verify)
+}
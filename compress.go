@@ -0,0 +1,54 @@
+package main
+
+import (
+	"github.com/moshuipan/goftp/transfer"
+)
+
+// mode switches the compression of the data stream for the rest of the session.
+func mode(s *Session, args []string) (out Buffer) {
+	//mode [s|z [codec]]
+	if len(args) == 1 {
+		if s.Codec == transfer.None {
+			out.Write([]byte("mode s\n"))
+		} else {
+			out.Write([]byte("mode z " + s.Codec + "\n"))
+		}
+		return
+	}
+	switch args[1] {
+	case "s", "S":
+		if len(args) != 2 {
+			out.Write([]byte("mode [s|z [codec]]\n"))
+			return
+		}
+		s.Codec = transfer.None
+	case "z", "Z":
+		c := option.Compression
+		if c == transfer.None {
+			c = transfer.Deflate
+		}
+		if len(args) == 3 {
+			c = args[2]
+		} else if len(args) > 3 {
+			out.Write([]byte("mode [s|z [codec]]\n"))
+			return
+		}
+		if !transfer.ValidCodec(c) {
+			out.Write([]byte("unknown codec " + c + "\n"))
+			return
+		}
+		s.Codec = c
+	default:
+		out.Write([]byte("mode [s|z [codec]]\n"))
+	}
+	return
+}
+
+// codecFor returns the codec used to transfer the file name.
+// Files which are compressed already are sent as they are.
+func codecFor(name string, codec string) string {
+	if skipCompression(name) {
+		return transfer.None
+	}
+	return codec
+}
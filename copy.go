@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// cpOpts are the options of cp.
+type cpOpts struct {
+	recursive bool
+	preserve  bool
+	noClobber bool
+}
+
+func cp(s *Session, args []string) error {
+	//cp [-rpn] dstdir+dstfilename src
+	//cp [-rpn] dstdir pattern
+	var opts cpOpts
+	var rest []string
+	for _, v := range args[1:] {
+		if len(v) < 2 || v[0] != '-' {
+			rest = append(rest, v)
+			continue
+		}
+		for _, c := range v[1:] {
+			switch c {
+			case 'r':
+				opts.recursive = true
+			case 'p':
+				opts.preserve = true
+			case 'n':
+				opts.noClobber = true
+			default:
+				return errors.New("cp: unknown option -" + string(c) + "\n")
+			}
+		}
+	}
+	if len(rest) != 2 {
+		return errors.New("cp [-rpn] dstdir+dstfilename src\n")
+	}
+	names, err := s.expand(rest[1])
+	if err != nil {
+		return err
+	}
+	dst, err := s.resolve(rest[0])
+	if err != nil {
+		return err
+	}
+	if fi, err := s.FS.Stat(dst); err != nil || !fi.IsDir() {
+		if len(names) > 1 {
+			return errors.New(rest[0] + " is not a directory\n")
+		}
+		return copyEntry(s, dst, names[0], opts)
+	}
+	var errs Buffer
+	for _, name := range names {
+		if err := copyEntry(s, filepath.Join(dst, filepath.Base(name)), name, opts); err != nil {
+			errs.Write([]byte(err.Error()))
+		}
+	}
+	if errs != nil {
+		return errors.New(string(errs))
+	}
+	return nil
+}
+
+// copyEntry copies the file or, with -r, the directory name to the host path dst.
+func copyEntry(s *Session, dst string, name string, opts cpOpts) error {
+	src, err := s.resolve(name)
+	if err != nil {
+		return err
+	}
+	fi, err := s.FS.Stat(src)
+	if err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	if !fi.IsDir() {
+		return copyFile(s, dst, src, fi, opts)
+	}
+	if !opts.recursive {
+		return errors.New(name + " is a directory, use cp -r\n")
+	}
+	if rel, err := filepath.Rel(src, dst); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.New("cannot copy " + name + " into itself\n")
+	}
+	return copyTree(s, dst, src, fi, opts)
+}
+
+// copyTree copies the directory src with everything below it to dst. It goes
+// on after errors and returns all of them.
+func copyTree(s *Session, dst, src string, fi os.FileInfo, opts cpOpts) error {
+	if err := s.FS.Mkdir(dst, 0755); err != nil {
+		if di, serr := s.FS.Lstat(dst); serr != nil || !di.IsDir() {
+			return errors.New(err.Error() + "\n")
+		}
+	}
+	entries, err := readDir(s.FS, src)
+	if err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	var errs Buffer
+	for _, v := range entries {
+		from, to := filepath.Join(src, v.Name()), filepath.Join(dst, v.Name())
+		var err error
+		switch {
+		case v.IsDir():
+			err = copyTree(s, to, from, v, opts)
+		case v.Mode()&os.ModeSymlink != 0:
+			err = copyLink(s, to, from, opts)
+		case v.Mode().IsRegular():
+			err = copyFile(s, to, from, v, opts)
+		default:
+			err = errors.New(s.clientPath(from) + " is not a regular file\n")
+		}
+		if err != nil {
+			errs.Write([]byte(err.Error()))
+		}
+	}
+	if opts.preserve {
+		// the entries written above changed the times of dst.
+		if err := s.FS.Chmod(dst, fi.Mode().Perm()); err != nil {
+			errs.Write([]byte(err.Error() + "\n"))
+		}
+		if err := s.FS.Chtimes(dst, fi.ModTime(), fi.ModTime()); err != nil {
+			errs.Write([]byte(err.Error() + "\n"))
+		}
+	}
+	if errs != nil {
+		return errors.New(string(errs))
+	}
+	return nil
+}
+
+// copyFile copies the regular file src with the info fi to dst. The copy is
+// written to a temporary file first, so a failed copy leaves dst alone.
+func copyFile(s *Session, dst, src string, fi os.FileInfo, opts cpOpts) error {
+	unlock, err := locks.lockBoth(s, dst, true, src, false)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	policy := option.Overwrite
+	if opts.noClobber {
+		policy = OverwriteFail
+	}
+	if policy == OverwriteFail {
+		if _, err := s.FS.Lstat(dst); err == nil {
+			return errors.New(s.clientPath(dst) + " exists already\n")
+		}
+	}
+	in, err := s.FS.Open(src)
+	if err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	defer in.Close()
+	f, err := s.FS.Create(dst)
+	if err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	defer f.Abort()
+	if _, err := io.Copy(f, in); err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	if opts.preserve {
+		if err := f.Chmod(fi.Mode().Perm()); err != nil {
+			return errors.New(err.Error() + "\n")
+		}
+		if err := f.Chtimes(fi.ModTime(), fi.ModTime()); err != nil {
+			return errors.New(err.Error() + "\n")
+		}
+	}
+	_, err = s.commit(f, dst, policy)
+	return err
+}
+
+// copyLink copies the symlink src to dst. The link is copied as it is, it is
+// never followed.
+func copyLink(s *Session, dst, src string, opts cpOpts) error {
+	target, err := s.FS.Readlink(src)
+	if err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	// a relative target depends on the directory the link is in, the copy
+	// must not point out of the root where the original did not.
+	resolved := target
+	if !filepath.IsAbs(target) {
+		resolved = filepath.Join(filepath.Dir(dst), target)
+	}
+	if rel, err := filepath.Rel(s.Root, resolved); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return errors.New(s.clientPath(dst) + " would point out of the root\n")
+	}
+	unlock, err := locks.lock(s, dst, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := s.FS.Lstat(dst); err == nil {
+		if opts.noClobber || option.Overwrite == OverwriteFail {
+			return errors.New(s.clientPath(dst) + " exists already\n")
+		}
+		if err := keepVersion(s.FS, dst); err != nil {
+			return errors.New(err.Error() + "\n")
+		}
+		if err := s.FS.Remove(dst); err != nil {
+			return errors.New(err.Error() + "\n")
+		}
+	}
+	if err := s.FS.Symlink(target, dst); err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCp(t *testing.T) {
+	root := testRoot(t, "src/a.txt", "src/sub/b.txt", "dst/a.txt", "one.txt")
+	s := &Session{Root: root, Dir: ".", FS: localDriver{}}
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, os.Chmod(filepath.Join(root, "src/sub/b.txt"), 0600))
+	assert.NoError(t, os.Chtimes(filepath.Join(root, "src/sub/b.txt"), old, old))
+	assert.NoError(t, os.Chtimes(filepath.Join(root, "src/sub"), old, old))
+	assert.NoError(t, os.Symlink("a.txt", filepath.Join(root, "src/link")))
+	read := func(name string) string {
+		data, _ := ioutil.ReadFile(filepath.Join(root, name))
+		return string(data)
+	}
+
+	assert.NoError(t, cp(s, []string{"cp", "two.txt", "one.txt"}))
+	assert.Equal(t, "one.txt", read("two.txt"))
+
+	assert.EqualError(t, cp(s, []string{"cp", "copy", "src"}), "src is a directory, use cp -r\n")
+	assert.EqualError(t, cp(s, []string{"cp", "-r", "src/sub/x", "src"}), "cannot copy src into itself\n")
+	assert.EqualError(t, cp(s, []string{"cp", "-x", "a", "b"}), "cp: unknown option -x\n")
+
+	assert.NoError(t, cp(s, []string{"cp", "-rp", "copy", "src"}))
+	assert.Equal(t, "src/sub/b.txt", read("copy/sub/b.txt"))
+	fi, err := os.Stat(filepath.Join(root, "copy/sub/b.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	assert.True(t, fi.ModTime().Equal(old))
+	fi, err = os.Stat(filepath.Join(root, "copy/sub"))
+	assert.NoError(t, err)
+	assert.True(t, fi.ModTime().Equal(old))
+	target, err := os.Readlink(filepath.Join(root, "copy/link"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a.txt", target)
+
+	// an existing directory receives the copy.
+	assert.NoError(t, cp(s, []string{"cp", "-r", "dst", "src"}))
+	assert.Equal(t, "src/a.txt", read("dst/src/a.txt"))
+
+	assert.EqualError(t, cp(s, []string{"cp", "-n", "dst/a.txt", "src/a.txt"}), "/dst/a.txt exists already\n")
+	assert.Equal(t, "dst/a.txt", read("dst/a.txt"))
+	assert.NoError(t, cp(s, []string{"cp", "dst/a.txt", "src/a.txt"}))
+	assert.Equal(t, "src/a.txt", read("dst/a.txt"))
+
+	// no temporary files are left behind.
+	tmp, _ := filepath.Glob(filepath.Join(root, "*", ".*.tmp"))
+	assert.Empty(t, tmp)
+}
+
+func TestCpLinks(t *testing.T) {
+	oldRoot, oldVersions := Root, option.Versions
+	defer func() { Root, option.Versions = oldRoot, oldVersions }()
+	root := testRoot(t, "x.txt", "n1/n2/src/a.txt", "keep/src/up")
+	Root, option.Versions = root, 1
+	s := &Session{Root: root, Dir: ".", FS: localDriver{}}
+	assert.NoError(t, os.Symlink("../../../x.txt", filepath.Join(root, "n1/n2/src/up")))
+
+	// the link points into the root from where it is, not from a shallower copy
+	assert.EqualError(t, cp(s, []string{"cp", "-r", "top", "n1/n2/src"}), "/top/up would point out of the root\n")
+	_, err := os.Lstat(filepath.Join(root, "top/up"))
+	assert.True(t, os.IsNotExist(err))
+	assert.NoError(t, cp(s, []string{"cp", "-r", "n1/n2/copy", "n1/n2/src"}))
+	target, err := os.Readlink(filepath.Join(root, "n1/n2/copy/up"))
+	assert.NoError(t, err)
+	assert.Equal(t, "../../../x.txt", target)
+
+	// a file replaced by a link is kept as a version
+	assert.EqualError(t, cp(s, []string{"cp", "-r", "keep", "n1/n2/src"}), "/keep/src/up would point out of the root\n")
+	assert.NoError(t, os.Symlink("a.txt", filepath.Join(root, "n1/n2/src/same")))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "keep/src/same"), []byte("old"), 0644))
+	assert.NoError(t, os.Remove(filepath.Join(root, "n1/n2/src/up")))
+	assert.NoError(t, cp(s, []string{"cp", "-r", "keep", "n1/n2/src"}))
+	target, err = os.Readlink(filepath.Join(root, "keep/src/same"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a.txt", target)
+	list, err := listVersions(s.FS, versionDir(s.FS, filepath.Join(root, "keep/src/same")))
+	assert.NoError(t, err)
+	assert.Len(t, list, 1)
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPointerSize bounds the size of a pointer file, larger files are never
+// taken for one.
+const maxPointerSize = 256
+
+// dedupDriver stores the content of every file once, named by its sha256, in
+// a blob directory. The namespace under the root keeps a small pointer file
+// for every regular file, holding the digest and size of its content.
+// Directories, symlinks, modes and times live in the namespace as usual, and
+// regular files which are no pointers are served as they are.
+//
+// Every blob has a reference count next to it. A blob is removed as soon as
+// no pointer refers to it any more, GC repairs the counts after a crash.
+type dedupDriver struct {
+	localDriver
+	root  string
+	blobs string
+	// mu serializes the changes of reference counts and pointers.
+	mu sync.Mutex
+}
+
+// pointer is the content of a pointer file.
+type pointer struct {
+	Blob string `json:"blob"`
+	Size int64  `json:"size"`
+}
+
+// sum returns the hex sha256 of the content.
+func (p *pointer) sum() string {
+	return strings.TrimPrefix(p.Blob, "sha256:")
+}
+
+// blobOwners maps every blob store in use to the root of its driver. The
+// reference counts and GC of a driver only know its own namespace, so two
+// namespaces never share a blob store.
+var blobOwners = struct {
+	sync.Mutex
+	roots map[string]string
+}{roots: map[string]string{}}
+
+// newDedupDriver returns the driver for the namespace root with the blob
+// store blobs. The blob store must not be reachable from the namespace, nor
+// be used by the driver of another namespace.
+func newDedupDriver(root, blobs string) (*dedupDriver, error) {
+	blobs, err := filepath.Abs(blobs)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(root, blobs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("blob directory %s is inside of the root", blobs)
+	}
+	blobOwners.Lock()
+	owner, ok := blobOwners.roots[blobs]
+	if !ok {
+		blobOwners.roots[blobs] = root
+	}
+	blobOwners.Unlock()
+	if ok && owner != root {
+		return nil, fmt.Errorf("blob directory %s is used for %s already", blobs, owner)
+	}
+	d := &dedupDriver{root: root, blobs: blobs}
+	// uploads which never finished
+	os.RemoveAll(d.tmpDir())
+	if err := os.MkdirAll(d.tmpDir(), 0700); err != nil {
+		return nil, err
+	}
+	if _, err := d.GC(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *dedupDriver) tmpDir() string {
+	return filepath.Join(d.blobs, "tmp")
+}
+
+func (d *dedupDriver) blobPath(sum string) string {
+	return filepath.Join(d.blobs, sum[:2], sum)
+}
+
+// readPointer returns the pointer in the file name with the info fi, nil if
+// it is no pointer file.
+func readPointer(name string, fi os.FileInfo) *pointer {
+	if !fi.Mode().IsRegular() || fi.Size() > maxPointerSize {
+		return nil
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		return nil
+	}
+	p := &pointer{}
+	if json.Unmarshal(data, p) != nil || !strings.HasPrefix(p.Blob, "sha256:") || len(p.sum()) != 2*sha256.Size {
+		return nil
+	}
+	if _, err := hex.DecodeString(p.sum()); err != nil {
+		return nil
+	}
+	return p
+}
+
+// blobInfo is the info of a pointer file with the size of its content.
+type blobInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (fi blobInfo) Size() int64 { return fi.size }
+
+// info turns the info of a pointer file into the info of its content.
+func info(name string, fi os.FileInfo) os.FileInfo {
+	if p := readPointer(name, fi); p != nil {
+		return blobInfo{fi, p.Size}
+	}
+	return fi
+}
+
+func (d *dedupDriver) Stat(name string) (os.FileInfo, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return info(name, fi), nil
+}
+
+func (d *dedupDriver) Lstat(name string) (os.FileInfo, error) {
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return nil, err
+	}
+	return info(name, fi), nil
+}
+
+func (d *dedupDriver) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	for i, v := range entries {
+		entries[i] = info(filepath.Join(name, v.Name()), v)
+	}
+	return entries, nil
+}
+
+// blobFile is the content of a pointer file opened for reading.
+type blobFile struct {
+	*os.File
+	info os.FileInfo
+}
+
+func (f *blobFile) Stat() (os.FileInfo, error) { return f.info, nil }
+
+func (d *dedupDriver) Open(name string) (File, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	p := readPointer(name, fi)
+	if p == nil {
+		return d.localDriver.Open(name)
+	}
+	f, err := os.Open(d.blobPath(p.sum()))
+	if err != nil {
+		return nil, err
+	}
+	return &blobFile{File: f, info: blobInfo{fi, p.Size}}, nil
+}
+
+func (d *dedupDriver) Create(name string) (Upload, error) {
+	ptr, err := createAtomic(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.TempFile(d.tmpDir(), "upload-*")
+	if err != nil {
+		ptr.Abort()
+		return nil, err
+	}
+	return &dedupUpload{File: data, d: d, ptr: ptr}, nil
+}
+
+func (d *dedupDriver) Remove(name string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fi, err := os.Lstat(name)
+	if err != nil {
+		return err
+	}
+	p := readPointer(name, fi)
+	if err := os.Remove(name); err != nil {
+		return err
+	}
+	if p != nil {
+		d.release(p.sum())
+	}
+	return nil
+}
+
+func (d *dedupDriver) Rename(from, to string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var replaced *pointer
+	if fi, err := os.Lstat(to); err == nil {
+		if src, err := os.Lstat(from); err == nil && os.SameFile(src, fi) {
+			return nil
+		}
+		replaced = readPointer(to, fi)
+	}
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	if replaced != nil {
+		d.release(replaced.sum())
+	}
+	return nil
+}
+
+// Link links the pointer file, the blob gets one more reference.
+func (d *dedupDriver) Link(from, to string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fi, err := os.Lstat(from)
+	if err != nil {
+		return err
+	}
+	p := readPointer(from, fi)
+	if err := os.Link(from, to); err != nil {
+		return err
+	}
+	if p != nil {
+		if err := d.setRefs(p.sum(), d.refs(p.sum())+1); err != nil {
+			os.Remove(to)
+			return err
+		}
+	}
+	return nil
+}
+
+// refs returns the reference count of the blob sum.
+func (d *dedupDriver) refs(sum string) int64 {
+	data, err := ioutil.ReadFile(d.blobPath(sum) + ".refs")
+	if err != nil {
+		return 0
+	}
+	n, _ := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	return n
+}
+
+func (d *dedupDriver) setRefs(sum string, n int64) error {
+	return ioutil.WriteFile(d.blobPath(sum)+".refs", []byte(strconv.FormatInt(n, 10)+"\n"), 0600)
+}
+
+// release drops a reference of the blob sum and removes the blob with the last one.
+func (d *dedupDriver) release(sum string) {
+	n := d.refs(sum) - 1
+	if n > 0 {
+		d.setRefs(sum, n)
+		return
+	}
+	os.Remove(d.blobPath(sum))
+	os.Remove(d.blobPath(sum) + ".refs")
+}
+
+// GC counts the pointers of the namespace, fixes the reference counts and
+// removes the blobs nobody refers to. It returns the number of removed blobs.
+func (d *dedupDriver) GC() (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	refs := map[string]int64{}
+	err := filepath.Walk(d.root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return nil
+		}
+		// pointers which were never committed
+		if strings.HasPrefix(fi.Name(), ".") && strings.HasSuffix(fi.Name(), ".tmp") {
+			return nil
+		}
+		if p := readPointer(path, fi); p != nil {
+			refs[p.sum()]++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	dirs, err := ioutil.ReadDir(d.blobs)
+	if err != nil {
+		return 0, err
+	}
+	removed := 0
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 {
+			continue
+		}
+		entries, err := ioutil.ReadDir(filepath.Join(d.blobs, dir.Name()))
+		if err != nil {
+			return removed, err
+		}
+		for _, v := range entries {
+			sum := v.Name()
+			if strings.HasSuffix(sum, ".refs") {
+				if _, err := os.Stat(d.blobPath(strings.TrimSuffix(sum, ".refs"))); os.IsNotExist(err) {
+					os.Remove(filepath.Join(d.blobs, dir.Name(), sum))
+				}
+				continue
+			}
+			n, ok := refs[sum]
+			if !ok {
+				os.Remove(d.blobPath(sum))
+				os.Remove(d.blobPath(sum) + ".refs")
+				removed++
+				continue
+			}
+			if d.refs(sum) != n {
+				if err := d.setRefs(sum, n); err != nil {
+					return removed, err
+				}
+			}
+		}
+	}
+	return removed, nil
+}
+
+// dedupUpload writes the content to a temporary file of the blob store. Commit
+// moves it to its blob, unless the blob exists already, and then commits the
+// pointer file.
+type dedupUpload struct {
+	*os.File
+	d            *dedupDriver
+	ptr          *atomicFile
+	atime, mtime time.Time
+	done         bool
+}
+
+func (u *dedupUpload) Chmod(mode os.FileMode) error {
+	return u.ptr.Chmod(mode)
+}
+
+// Chtimes sets the times of the pointer file, once it has been written.
+func (u *dedupUpload) Chtimes(atime, mtime time.Time) error {
+	u.atime, u.mtime = atime, mtime
+	return nil
+}
+
+func (u *dedupUpload) Commit(policy string) (string, error) {
+	if u.done {
+		return "", errors.New("file already committed\n")
+	}
+	u.done = true
+	defer u.ptr.Abort()
+	defer os.Remove(u.File.Name())
+	defer u.File.Close()
+	h := sha256.New()
+	if _, err := u.Seek(0, io.SeekStart); err != nil {
+		return "", errors.New(err.Error() + "\n")
+	}
+	size, err := io.Copy(h, u.File)
+	if err != nil {
+		return "", errors.New(err.Error() + "\n")
+	}
+	if err := u.Sync(); err != nil {
+		return "", errors.New(err.Error() + "\n")
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	d := u.d
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	blob := d.blobPath(sum)
+	if _, err := os.Stat(blob); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(blob), 0700); err != nil {
+			return "", errors.New(err.Error() + "\n")
+		}
+		if err := os.Rename(u.File.Name(), blob); err != nil {
+			return "", errors.New(err.Error() + "\n")
+		}
+	}
+	if err := d.setRefs(sum, d.refs(sum)+1); err != nil {
+		d.release(sum)
+		return "", errors.New(err.Error() + "\n")
+	}
+	data, _ := json.Marshal(&pointer{Blob: "sha256:" + sum, Size: size})
+	if _, err := u.ptr.Write(data); err != nil {
+		d.release(sum)
+		return "", errors.New(err.Error() + "\n")
+	}
+	if !u.mtime.IsZero() {
+		if err := u.ptr.Chtimes(u.atime, u.mtime); err != nil {
+			d.release(sum)
+			return "", errors.New(err.Error() + "\n")
+		}
+	}
+	var replaced *pointer
+	if policy == OverwriteReplace {
+		if fi, err := os.Lstat(u.ptr.name); err == nil {
+			replaced = readPointer(u.ptr.name, fi)
+		}
+	}
+	name, err := u.ptr.Commit(policy)
+	if err != nil {
+		d.release(sum)
+		return "", err
+	}
+	if replaced != nil {
+		d.release(replaced.sum())
+	}
+	return name, nil
+}
+
+func (u *dedupUpload) Abort() {
+	if u.done {
+		return
+	}
+	u.done = true
+	u.ptr.Abort()
+	u.File.Close()
+	os.Remove(u.File.Name())
+}
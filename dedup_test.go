@@ -0,0 +1,142 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDedupDriver(t *testing.T) {
+	root, blobs := testRoot(t, "plain.txt"), testRoot(t)
+	d, err := newDedupDriver(root, blobs)
+	assert.NoError(t, err)
+	path := func(name string) string { return filepath.Join(root, name) }
+	put := func(name, content, policy string) error {
+		f, err := d.Create(path(name))
+		assert.NoError(t, err)
+		defer f.Abort()
+		f.Write([]byte(content))
+		_, err = f.Commit(policy)
+		return err
+	}
+	get := func(name string) string {
+		f, err := d.Open(path(name))
+		if err != nil {
+			return err.Error()
+		}
+		defer f.Close()
+		data, _ := ioutil.ReadAll(f)
+		return string(data)
+	}
+	sum := func(content string) string {
+		s := sha256.Sum256([]byte(content))
+		return hex.EncodeToString(s[:])
+	}
+	blobCount := func() int {
+		list, _ := filepath.Glob(filepath.Join(blobs, "??", "*"))
+		n := 0
+		for _, v := range list {
+			if filepath.Ext(v) != ".refs" {
+				n++
+			}
+		}
+		return n
+	}
+
+	assert.NoError(t, put("a", "installer", OverwriteReplace))
+	assert.NoError(t, put("b", "installer", OverwriteReplace))
+	assert.Equal(t, 1, blobCount())
+	assert.Equal(t, int64(2), d.refs(sum("installer")))
+	assert.Equal(t, "installer", get("b"))
+	fi, err := d.Stat(path("a"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("installer")), fi.Size())
+	entries, err := d.ReadDir(root)
+	assert.NoError(t, err)
+	for _, v := range entries {
+		if v.Name() == "b" {
+			assert.Equal(t, int64(len("installer")), v.Size())
+		}
+	}
+
+	// files written before the driver was used are served as they are.
+	assert.Equal(t, "plain.txt", get("plain.txt"))
+
+	assert.NoError(t, put("a", "dataset", OverwriteReplace))
+	assert.Equal(t, int64(1), d.refs(sum("installer")))
+	assert.Equal(t, 2, blobCount())
+	assert.Error(t, put("a", "other", OverwriteFail))
+	assert.Equal(t, 2, blobCount())
+	assert.Equal(t, "dataset", get("a"))
+
+	assert.NoError(t, d.Remove(path("b")))
+	assert.Equal(t, 1, blobCount())
+	assert.NoError(t, put("c", "replaced", OverwriteReplace))
+	assert.NoError(t, d.Rename(path("a"), path("c")))
+	assert.Equal(t, "dataset", get("c"))
+	assert.Equal(t, 1, blobCount())
+
+	// times are kept by the pointer
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	f, err := d.Create(path("d"))
+	assert.NoError(t, err)
+	f.Write([]byte("dataset"))
+	f.Chtimes(old, old)
+	_, err = f.Commit(OverwriteReplace)
+	assert.NoError(t, err)
+	fi, err = d.Stat(path("d"))
+	assert.NoError(t, err)
+	assert.True(t, fi.ModTime().Equal(old))
+	assert.Equal(t, int64(2), d.refs(sum("dataset")))
+
+	// an aborted upload leaves nothing behind
+	f, err = d.Create(path("e"))
+	assert.NoError(t, err)
+	f.Write([]byte("partial"))
+	f.Abort()
+	tmp, _ := ioutil.ReadDir(d.tmpDir())
+	assert.Empty(t, tmp)
+	_, err = os.Lstat(path("e"))
+	assert.True(t, os.IsNotExist(err))
+
+	// GC repairs wrong counts and removes blobs nobody refers to
+	assert.NoError(t, d.setRefs(sum("dataset"), 7))
+	orphan := d.blobPath(sum("orphan"))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(orphan), 0700))
+	assert.NoError(t, ioutil.WriteFile(orphan, []byte("orphan"), 0600))
+	removed, err := d.GC()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.Equal(t, int64(2), d.refs(sum("dataset")))
+	assert.Equal(t, 1, blobCount())
+
+	_, err = newDedupDriver(root, filepath.Join(root, "blobs"))
+	assert.Error(t, err)
+	// the counts only cover one namespace, so its blobs are its own
+	other := testRoot(t)
+	_, err = newDedupDriver(other, blobs)
+	assert.EqualError(t, err, "blob directory "+blobs+" is used for "+root+" already")
+}
+
+func TestCpDedup(t *testing.T) {
+	root, blobs := testRoot(t, "src/a.txt", "src/sub/b.txt"), testRoot(t)
+	d, err := newDedupDriver(root, blobs)
+	assert.NoError(t, err)
+	s := &Session{Root: root, Dir: ".", FS: d}
+	assert.NoError(t, cp(s, []string{"cp", "-r", "one", "src"}))
+	assert.NoError(t, cp(s, []string{"cp", "-r", "two", "one"}))
+	f, err := d.Open(filepath.Join(root, "two/sub/b.txt"))
+	assert.NoError(t, err)
+	data, _ := ioutil.ReadAll(f)
+	f.Close()
+	assert.Equal(t, "src/sub/b.txt", string(data))
+	// the two copies share their blobs
+	list, _ := filepath.Glob(filepath.Join(blobs, "??", "*.refs"))
+	assert.Len(t, list, 2)
+}
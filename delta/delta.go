@@ -0,0 +1,187 @@
+// Package delta implements the rsync algorithm used by the sync command.
+//
+// The receiver, which holds an old copy of a file, sends the Signature of that
+// copy: a weak rolling checksum and a strong hash for every block. The sender
+// slides a window over its new version and, using Diff, encodes it as copies
+// of blocks the receiver has and literal data for everything else. Patch
+// rebuilds the new version from the old copy and that encoding.
+package delta
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+)
+
+const (
+	// MinBlockSize and MaxBlockSize bound the block size chosen by BlockSize.
+	MinBlockSize = 1024
+	MaxBlockSize = 64 * 1024
+
+	// StrongSize is the number of bytes kept of the sha256 of a block.
+	StrongSize = 16
+
+	// maxBlocks bounds the signature a peer may send.
+	maxBlocks = 1 << 24
+)
+
+// Block is the signature of one block of the old copy.
+type Block struct {
+	Weak   uint32
+	Strong [StrongSize]byte
+}
+
+// Signature describes the old copy of a file. All blocks are BlockSize bytes
+// long except for the last one, which holds the rest of the Size bytes.
+type Signature struct {
+	BlockSize int
+	Size      int64
+	Blocks    []Block
+}
+
+// BlockSize returns the block size used for a file of size bytes. Like rsync
+// it grows with the square root of the size.
+func BlockSize(size int64) int {
+	n := int(math.Sqrt(float64(size))) &^ 7
+	switch {
+	case n < MinBlockSize:
+		return MinBlockSize
+	case n > MaxBlockSize:
+		return MaxBlockSize
+	}
+	return n
+}
+
+// NewSignature reads the old copy from r and returns its signature.
+func NewSignature(r io.Reader, blockSize int) (*Signature, error) {
+	if blockSize <= 0 {
+		return nil, errors.New("delta: bad block size")
+	}
+	sig := &Signature{BlockSize: blockSize}
+	buf := make([]byte, blockSize)
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			sig.Size += int64(n)
+			sig.Blocks = append(sig.Blocks, Block{Weak: weakSum(buf[:n]), Strong: strongSum(buf[:n])})
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return sig, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// blockLen returns the length of block i.
+func (sig *Signature) blockLen(i int) int {
+	if i == len(sig.Blocks)-1 {
+		return int(sig.Size - int64(i)*int64(sig.BlockSize))
+	}
+	return sig.BlockSize
+}
+
+// WriteTo writes sig in its binary form: block size, size and number of blocks
+// followed by the blocks.
+func (sig *Signature) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	var hdr [16]byte
+	binary.BigEndian.PutUint32(hdr[0:], uint32(sig.BlockSize))
+	binary.BigEndian.PutUint64(hdr[4:], uint64(sig.Size))
+	binary.BigEndian.PutUint32(hdr[12:], uint32(len(sig.Blocks)))
+	bw.Write(hdr[:])
+	var b [4 + StrongSize]byte
+	for _, v := range sig.Blocks {
+		binary.BigEndian.PutUint32(b[:], v.Weak)
+		copy(b[4:], v.Strong[:])
+		bw.Write(b[:])
+	}
+	if err := bw.Flush(); err != nil {
+		return 0, err
+	}
+	return int64(len(hdr) + len(sig.Blocks)*len(b)), nil
+}
+
+// ReadSignature reads a signature written by WriteTo.
+func ReadSignature(r io.Reader) (*Signature, error) {
+	br := bufio.NewReader(r)
+	var hdr [16]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return nil, err
+	}
+	sig := &Signature{
+		BlockSize: int(binary.BigEndian.Uint32(hdr[0:])),
+		Size:      int64(binary.BigEndian.Uint64(hdr[4:])),
+	}
+	n := int64(binary.BigEndian.Uint32(hdr[12:]))
+	if sig.BlockSize <= 0 || sig.BlockSize > MaxBlockSize || n > maxBlocks || sig.Size < 0 ||
+		n != (sig.Size+int64(sig.BlockSize)-1)/int64(sig.BlockSize) {
+		return nil, errors.New("delta: bad signature")
+	}
+	sig.Blocks = make([]Block, n)
+	var b [4 + StrongSize]byte
+	for i := range sig.Blocks {
+		if _, err := io.ReadFull(br, b[:]); err != nil {
+			return nil, err
+		}
+		sig.Blocks[i].Weak = binary.BigEndian.Uint32(b[:])
+		copy(sig.Blocks[i].Strong[:], b[4:])
+	}
+	return sig, nil
+}
+
+// weakSum is the rolling checksum of rsync: a is the sum of the bytes, b the
+// sum of the bytes weighted by their distance from the end of the block.
+func weakSum(p []byte) uint32 {
+	var a, b uint32
+	n := uint32(len(p))
+	for i, c := range p {
+		a += uint32(c)
+		b += (n - uint32(i)) * uint32(c)
+	}
+	return a&0xffff | b<<16
+}
+
+func strongSum(p []byte) [StrongSize]byte {
+	var s [StrongSize]byte
+	sum := sha256.Sum256(p)
+	copy(s[:], sum[:])
+	return s
+}
+
+// rolling keeps the weak checksum of a window which moves over the data.
+type rolling struct {
+	a, b uint32
+	n    uint32
+}
+
+func newRolling(p []byte) rolling {
+	r := rolling{n: uint32(len(p))}
+	for i, c := range p {
+		r.a += uint32(c)
+		r.b += (r.n - uint32(i)) * uint32(c)
+	}
+	return r
+}
+
+// roll moves the window one byte: out leaves it at the front, in enters it
+// at the end.
+func (r *rolling) roll(out, in byte) {
+	r.a += uint32(in) - uint32(out)
+	r.b += r.a - r.n*uint32(out)
+}
+
+// shrink drops out from the front of the window, at the end of the data.
+func (r *rolling) shrink(out byte) {
+	r.a -= uint32(out)
+	r.b -= r.n * uint32(out)
+	r.n--
+}
+
+func (r *rolling) sum() uint32 {
+	return r.a&0xffff | r.b<<16
+}
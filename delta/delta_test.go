@@ -0,0 +1,103 @@
+package delta
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// roundTrip encodes new against old and applies the result to old.
+func roundTrip(t *testing.T, old, new []byte, blockSize int) Stats {
+	sig, err := NewSignature(bytes.NewReader(old), blockSize)
+	assert.NoError(t, err)
+	var wire bytes.Buffer
+	_, err = sig.WriteTo(&wire)
+	assert.NoError(t, err)
+	sig, err = ReadSignature(&wire)
+	assert.NoError(t, err)
+
+	var enc bytes.Buffer
+	stats, err := Diff(&enc, bytes.NewReader(new), sig)
+	assert.NoError(t, err)
+	var got bytes.Buffer
+	applied, err := Patch(&got, bytes.NewReader(old), sig, &enc)
+	assert.NoError(t, err)
+	assert.Equal(t, stats, applied)
+	assert.True(t, bytes.Equal(new, got.Bytes()), "rebuilt file differs")
+	assert.Equal(t, int64(len(new)), stats.Matched+stats.Literal)
+	return stats
+}
+
+func TestDiffPatch(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	random := func(n int) []byte {
+		p := make([]byte, n)
+		rnd.Read(p)
+		return p
+	}
+	join := func(parts ...[]byte) []byte { return bytes.Join(parts, nil) }
+	old := random(300 * 1024)
+
+	// unchanged
+	stats := roundTrip(t, old, old, MinBlockSize)
+	assert.Equal(t, int64(0), stats.Literal)
+
+	// a few bytes inserted in the middle, everything else shifts
+	stats = roundTrip(t, old, join(old[:100000], []byte("inserted"), old[100000:]), MinBlockSize)
+	assert.True(t, stats.Literal < 2*MinBlockSize, "literal %d", stats.Literal)
+
+	// bytes changed and the end cut off in the middle of a block
+	changed := append([]byte(nil), old[:250000]...)
+	copy(changed[5000:], "changed")
+	stats = roundTrip(t, old, changed, MinBlockSize)
+	assert.True(t, stats.Literal < 2*MinBlockSize, "literal %d", stats.Literal)
+
+	// the short last block is found again at the end
+	stats = roundTrip(t, old[:MinBlockSize+10], join(random(5), old[:MinBlockSize+10]), MinBlockSize)
+	assert.Equal(t, int64(5), stats.Literal)
+
+	// nothing in common, longer than MaxLiteral
+	stats = roundTrip(t, old, random(200*1024), MinBlockSize)
+	assert.Equal(t, int64(0), stats.Matched)
+
+	// empty files on either side
+	roundTrip(t, nil, old[:5000], MinBlockSize)
+	roundTrip(t, old, nil, MinBlockSize)
+	roundTrip(t, nil, nil, MinBlockSize)
+}
+
+func TestRolling(t *testing.T) {
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	r := newRolling(data[:8])
+	for i := 8; i < len(data); i++ {
+		r.roll(data[i-8], data[i])
+		assert.Equal(t, weakSum(data[i-7:i+1]), r.sum())
+	}
+	tail := data[len(data)-8:]
+	for i := 0; i < 7; i++ {
+		r.shrink(tail[i])
+		assert.Equal(t, weakSum(tail[i+1:]), r.sum())
+	}
+}
+
+func TestBlockSize(t *testing.T) {
+	assert.Equal(t, MinBlockSize, BlockSize(0))
+	assert.Equal(t, 4096, BlockSize(4096*4096))
+	assert.Equal(t, MaxBlockSize, BlockSize(1<<40))
+}
+
+func TestPatchCorrupt(t *testing.T) {
+	sig, _ := NewSignature(bytes.NewReader(make([]byte, 3000)), MinBlockSize)
+	for _, enc := range [][]byte{
+		{opCopy, 2, 2},
+		{opLiteral, 0},
+		{9},
+	} {
+		_, err := Patch(&bytes.Buffer{}, bytes.NewReader(make([]byte, 3000)), sig, bytes.NewReader(enc))
+		assert.Equal(t, ErrCorrupt, err)
+	}
+	_, err := Patch(&bytes.Buffer{}, bytes.NewReader(nil), sig, bytes.NewReader([]byte{opCopy, 0, 1}))
+	assert.Error(t, err)
+}
@@ -0,0 +1,241 @@
+package delta
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// Operations of the encoding written by Diff. A copy is followed by the index
+// of the first block and the number of blocks, a literal by its length and
+// data, all numbers as uvarints.
+const (
+	opEnd     = 0
+	opCopy    = 1
+	opLiteral = 2
+)
+
+// MaxLiteral is the longest literal written in one operation.
+const MaxLiteral = 64 * 1024
+
+// ErrCorrupt is returned by Patch for an encoding it cannot apply.
+var ErrCorrupt = errors.New("delta: corrupt delta")
+
+// Stats counts the bytes of the new version which were copied from the old
+// copy and those which were sent as literal data.
+type Stats struct {
+	Matched int64
+	Literal int64
+}
+
+// encoder writes the operations, merging copies of consecutive blocks.
+type encoder struct {
+	w            *bufio.Writer
+	first, count int
+	stats        Stats
+	num          [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) uvarint(v uint64) {
+	e.w.Write(e.num[:binary.PutUvarint(e.num[:], v)])
+}
+
+func (e *encoder) copyBlock(i, n int) {
+	e.stats.Matched += int64(n)
+	if e.count > 0 && e.first+e.count == i {
+		e.count++
+		return
+	}
+	e.flushCopy()
+	e.first, e.count = i, 1
+}
+
+func (e *encoder) flushCopy() {
+	if e.count == 0 {
+		return
+	}
+	e.w.WriteByte(opCopy)
+	e.uvarint(uint64(e.first))
+	e.uvarint(uint64(e.count))
+	e.count = 0
+}
+
+func (e *encoder) literal(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	e.flushCopy()
+	e.stats.Literal += int64(len(p))
+	e.w.WriteByte(opLiteral)
+	e.uvarint(uint64(len(p)))
+	e.w.Write(p)
+}
+
+// Diff reads the new version from r and writes its encoding against sig to w.
+func Diff(w io.Writer, r io.Reader, sig *Signature) (Stats, error) {
+	if sig.BlockSize <= 0 {
+		return Stats{}, errors.New("delta: bad block size")
+	}
+	e := &encoder{w: bufio.NewWriter(w)}
+	index := make(map[uint32][]int, len(sig.Blocks))
+	for i, b := range sig.Blocks {
+		index[b.Weak] = append(index[b.Weak], i)
+	}
+	br := bufio.NewReader(r)
+	bs := sig.BlockSize
+	// buf holds the pending literal data followed by the window.
+	buf := make([]byte, 0, MaxLiteral+bs)
+	fill := func() error {
+		n, err := io.ReadFull(br, buf[len(buf):len(buf)+bs])
+		buf = buf[:len(buf)+n]
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil
+		}
+		return err
+	}
+	if err := fill(); err != nil {
+		return e.stats, err
+	}
+	start := 0
+	sum := newRolling(buf)
+	eof := len(buf) < bs
+	for start < len(buf) {
+		window := buf[start:]
+		if i, ok := match(sig, index, sum.sum(), window); ok {
+			e.literal(buf[:start])
+			e.copyBlock(i, len(window))
+			buf, start = buf[:0], 0
+			if !eof {
+				if err := fill(); err != nil {
+					return e.stats, err
+				}
+				eof = len(buf) < bs
+			}
+			sum = newRolling(buf)
+			continue
+		}
+		out := buf[start]
+		if !eof {
+			in, err := br.ReadByte()
+			switch {
+			case err == io.EOF:
+				eof = true
+			case err != nil:
+				return e.stats, err
+			default:
+				buf = append(buf, in)
+				sum.roll(out, in)
+			}
+		}
+		if eof {
+			sum.shrink(out)
+		}
+		start++
+		if start == MaxLiteral {
+			e.literal(buf[:start])
+			buf = buf[:copy(buf, buf[start:])]
+			start = 0
+		}
+	}
+	e.literal(buf[:start])
+	e.flushCopy()
+	e.w.WriteByte(opEnd)
+	return e.stats, e.w.Flush()
+}
+
+// match returns the block of sig whose content equals window.
+func match(sig *Signature, index map[uint32][]int, weak uint32, window []byte) (int, bool) {
+	candidates, ok := index[weak]
+	if !ok {
+		return 0, false
+	}
+	var strong [StrongSize]byte
+	hashed := false
+	for _, i := range candidates {
+		if sig.blockLen(i) != len(window) {
+			continue
+		}
+		if !hashed {
+			strong, hashed = strongSum(window), true
+		}
+		if sig.Blocks[i].Strong == strong {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Patch reads the encoding written by Diff from r and writes the new version
+// to w, copying blocks from base, the old copy described by sig.
+func Patch(w io.Writer, base io.ReaderAt, sig *Signature, r io.Reader) (Stats, error) {
+	var stats Stats
+	br := bufio.NewReader(r)
+	buf := make([]byte, MaxLiteral)
+	if sig.BlockSize > MaxLiteral {
+		buf = make([]byte, sig.BlockSize)
+	}
+	for {
+		op, err := br.ReadByte()
+		if err != nil {
+			return stats, unexpected(err)
+		}
+		switch op {
+		case opEnd:
+			return stats, nil
+		case opCopy:
+			first, err := binary.ReadUvarint(br)
+			if err != nil {
+				return stats, unexpected(err)
+			}
+			count, err := binary.ReadUvarint(br)
+			if err != nil {
+				return stats, unexpected(err)
+			}
+			if first >= uint64(len(sig.Blocks)) || count > uint64(len(sig.Blocks))-first {
+				return stats, ErrCorrupt
+			}
+			for i := int(first); i < int(first+count); i++ {
+				p := buf[:sig.blockLen(i)]
+				// ReadAt may return io.EOF along with the last block.
+				if n, err := base.ReadAt(p, int64(i)*int64(sig.BlockSize)); n < len(p) {
+					if err == nil {
+						err = io.ErrUnexpectedEOF
+					}
+					return stats, unexpected(err)
+				}
+				if _, err := w.Write(p); err != nil {
+					return stats, err
+				}
+				stats.Matched += int64(len(p))
+			}
+		case opLiteral:
+			n, err := binary.ReadUvarint(br)
+			if err != nil {
+				return stats, unexpected(err)
+			}
+			if n == 0 || n > MaxLiteral {
+				return stats, ErrCorrupt
+			}
+			p := buf[:n]
+			if _, err := io.ReadFull(br, p); err != nil {
+				return stats, unexpected(err)
+			}
+			if _, err := w.Write(p); err != nil {
+				return stats, err
+			}
+			stats.Literal += int64(n)
+		default:
+			return stats, ErrCorrupt
+		}
+	}
+}
+
+// unexpected turns the end of the input in the middle of an operation into
+// io.ErrUnexpectedEOF.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// The SFTP and HTTP gateways work on open files rather than on data
+// connections. The types here give their transfers the locks, hooks and
+// policies of dl and ul. Their errors carry no newline.
+
+// trimError strips the newline the errors of the commands end with.
+func trimError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*os.PathError); ok {
+		return err
+	}
+	return errors.New(strings.TrimSuffix(err.Error(), "\n"))
+}
+
+// fileDownload is a file being downloaded.
+type fileDownload struct {
+	File
+	r      *io.SectionReader
+	s      *Session
+	event  FileEvent
+	unlock func()
+	p      *progress
+	read   int32
+}
+
+// openDownload opens the file name of s for reading, if the OnDownload hooks let it.
+func openDownload(s *Session, name string) (*fileDownload, error) {
+	unlock, err := locks.lock(s, name, false)
+	if err != nil {
+		return nil, trimError(err)
+	}
+	f, err := s.FS.Open(name)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err == nil && fi.IsDir() {
+		err = errors.New(s.clientPath(name) + " is a directory")
+	}
+	if err != nil {
+		f.Close()
+		unlock()
+		return nil, err
+	}
+	event := s.fileEvent(name, fi)
+	if err := check(func(h Hooks) error { return h.OnDownload(s, event) }); err != nil {
+		f.Close()
+		unlock()
+		return nil, trimError(err)
+	}
+	d := &fileDownload{File: f, r: io.NewSectionReader(f, 0, fi.Size()), s: s, event: event, unlock: unlock}
+	d.p = s.startTransfer(name, false, fi.Size())
+	return d, nil
+}
+
+func (d *fileDownload) Read(p []byte) (int, error) {
+	n, err := d.r.Read(p)
+	d.count(n)
+	return n, err
+}
+
+func (d *fileDownload) ReadAt(p []byte, off int64) (int, error) {
+	n, err := d.r.ReadAt(p, off)
+	d.count(n)
+	return n, err
+}
+
+func (d *fileDownload) Seek(offset int64, whence int) (int64, error) {
+	return d.r.Seek(offset, whence)
+}
+
+func (d *fileDownload) count(n int) {
+	atomic.StoreInt32(&d.read, 1)
+	d.p.add(n)
+}
+
+// Close counts the download, unless the client never read from it, as with
+// the HEAD requests of the HTTP gateway.
+func (d *fileDownload) Close() error {
+	err := d.File.Close()
+	d.unlock()
+	d.s.endTransfer(d.p)
+	if atomic.LoadInt32(&d.read) == 1 {
+		d.s.sent(atomic.LoadInt64(&d.p.done))
+		notify(func(h Hooks) error { return h.OnDownload(d.s, d.event) })
+	}
+	return err
+}
+
+// fileUpload is a file being uploaded. Like ul it writes a temporary file, which
+// replaces the file according to the overwrite policy once it is closed.
+type fileUpload struct {
+	Upload
+	s      *Session
+	name   string
+	unlock func()
+	p      *progress
+	failed error
+}
+
+// createUpload starts the upload of the file name of s.
+func createUpload(s *Session, name string) (*fileUpload, error) {
+	unlock, err := locks.lock(s, name, true)
+	if err != nil {
+		return nil, trimError(err)
+	}
+	if option.Overwrite == OverwriteFail {
+		if _, err := s.FS.Lstat(name); err == nil {
+			unlock()
+			return nil, &os.PathError{Op: "create", Path: s.clientPath(name), Err: os.ErrExist}
+		}
+	}
+	f, err := s.FS.Create(name)
+	if err != nil {
+		unlock()
+		uploadFailed(s, s.fileEvent(name, nil), err)
+		return nil, err
+	}
+	p := s.startTransfer(name, true, 0)
+	return &fileUpload{Upload: progressUpload{Upload: f, p: p}, s: s, name: name, unlock: unlock, p: p}, nil
+}
+
+// TransferError throws the upload away when the transfer breaks, Close
+// reports it as failed then.
+func (u *fileUpload) TransferError(err error) {
+	u.failed = err
+	u.Abort()
+}
+
+func (u *fileUpload) Close() (err error) {
+	s := u.s
+	defer u.unlock()
+	defer s.endTransfer(u.p)
+	defer func() {
+		if err != nil {
+			u.Abort()
+			uploadFailed(s, s.fileEvent(u.name, nil), err)
+			err = trimError(err)
+		}
+	}()
+	if u.failed != nil {
+		return u.failed
+	}
+	fi, err := u.Stat()
+	if err != nil {
+		return err
+	}
+	if err := check(func(h Hooks) error { return h.OnUpload(s, s.fileEvent(u.name, fi)) }); err != nil {
+		return err
+	}
+	final, err := s.commit(u.Upload, u.name, option.Overwrite)
+	if err != nil {
+		return err
+	}
+	s.received(fi.Size())
+	notify(func(h Hooks) error { return h.OnUpload(s, s.fileEvent(final, fi)) })
+	return nil
+}
+
+// renameEntry renames from to to in s, which must not exist unless replace is
+// set. A replaced file is kept as a version, like with ul.
+func renameEntry(s *Session, from, to string, replace bool) error {
+	unlock, err := locks.lockBoth(s, from, true, to, true)
+	if err != nil {
+		return trimError(err)
+	}
+	defer unlock()
+	if _, err := s.FS.Lstat(to); err == nil && !replace {
+		return &os.LinkError{Op: "rename", Old: s.clientPath(from), New: s.clientPath(to), Err: os.ErrExist}
+	}
+	event := FileEvent{Path: s.clientPath(from), To: s.clientPath(to)}
+	if err := check(func(h Hooks) error { return h.OnRename(s, event) }); err != nil {
+		return trimError(err)
+	}
+	if replace && from != to {
+		if err := keepVersion(s.FS, to); err != nil {
+			return err
+		}
+	}
+	if err := s.FS.Rename(from, to); err != nil {
+		return err
+	}
+	notify(func(h Hooks) error { return h.OnRename(s, event) })
+	return nil
+}
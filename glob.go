@@ -0,0 +1,157 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/moshuipan/goftp/transfer"
+)
+
+// expand returns the paths matching pattern, in the form the pattern is written.
+// Patterns support *, ?, [...] within one path element and ** for any number of
+// directories. Like in a shell, hidden files only match patterns starting with
+// a dot, a quoted pattern and a pattern naming an existing file are taken
+// literally. Nothing outside Root is matched.
+func (s *Session) expand(pattern string) ([]string, error) {
+	if !transfer.HasGlob(pattern) || s.literal[pattern] {
+		return []string{pattern}, nil
+	}
+	if path, err := s.resolve(pattern); err == nil {
+		if _, err := s.FS.Lstat(path); err == nil {
+			return []string{pattern}, nil
+		}
+	}
+	if _, err := filepath.Match(filepath.Base(pattern), ""); err != nil {
+		return nil, errors.New("bad pattern " + pattern + "\n")
+	}
+	var matches []string
+	var walk func(prefix string, elems []string) error
+	walk = func(prefix string, elems []string) error {
+		if len(elems) == 0 {
+			if len(matches) >= option.MaxGlobMatches {
+				return errors.New(pattern + " matches more than " + strconv.Itoa(option.MaxGlobMatches) + " files\n")
+			}
+			matches = append(matches, prefix)
+			return nil
+		}
+		elem := elems[0]
+		switch {
+		case elem == "" || elem == ".":
+			return walk(joinElem(prefix, elem), elems[1:])
+		case elem == "**":
+			if err := walk(prefix, elems[1:]); err != nil {
+				return err
+			}
+			entries, err := s.readPrefix(prefix)
+			if err != nil {
+				return err
+			}
+			for _, v := range entries {
+				// symlinks are not followed, they might lead out of the root.
+				if v.IsDir() && !strings.HasPrefix(v.Name(), ".") {
+					if err := walk(joinElem(prefix, v.Name()), elems); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		case !transfer.HasGlob(elem):
+			next := joinElem(prefix, elem)
+			path, err := s.resolve(next)
+			if err != nil {
+				return err
+			}
+			if _, err := s.FS.Lstat(path); err != nil {
+				return nil
+			}
+			return walk(next, elems[1:])
+		}
+		entries, err := s.readPrefix(prefix)
+		if err != nil {
+			return err
+		}
+		for _, v := range entries {
+			if strings.HasPrefix(v.Name(), ".") && !strings.HasPrefix(elem, ".") {
+				continue
+			}
+			ok, err := filepath.Match(elem, v.Name())
+			if err != nil {
+				return errors.New("bad pattern " + pattern + "\n")
+			}
+			if ok {
+				if err := walk(joinElem(prefix, v.Name()), elems[1:]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	prefix, elems := "", strings.Split(filepath.ToSlash(pattern), "/")
+	if elems[0] == "" {
+		prefix, elems = "/", elems[1:]
+	}
+	if err := walk(prefix, elems); err != nil {
+		return nil, err
+	}
+	if len(matches) == 0 {
+		return nil, errors.New("no match for " + pattern + "\n")
+	}
+	return matches, nil
+}
+
+// readPrefix reads the directory prefix of a pattern. Directories which
+// cannot be read have no entries.
+func (s *Session) readPrefix(prefix string) ([]os.FileInfo, error) {
+	if prefix == "" {
+		prefix = "."
+	}
+	path, err := s.resolve(prefix)
+	if err != nil {
+		return nil, err
+	}
+	entries, _ := readDir(s.FS, path)
+	return entries, nil
+}
+
+// joinElem appends the path element elem to prefix.
+func joinElem(prefix, elem string) string {
+	switch prefix {
+	case "":
+		return elem
+	case "/":
+		return "/" + elem
+	}
+	if elem == "" {
+		return prefix
+	}
+	return prefix + "/" + elem
+}
+
+// matchPath reports whether the slash separated name matches pattern, which
+// may use ** like expand. Both are taken relative to the root.
+func matchPath(pattern, name string) bool {
+	return matchElems(strings.Split(strings.Trim(pattern, "/"), "/"), strings.Split(strings.Trim(name, "/"), "/"))
+}
+
+func matchElems(pattern, name []string) bool {
+	if len(pattern) == 0 {
+		return len(name) == 0
+	}
+	if pattern[0] == "**" {
+		for i := 0; i <= len(name); i++ {
+			if matchElems(pattern[1:], name[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(name) == 0 {
+		return false
+	}
+	ok, _ := path.Match(pattern[0], name[0])
+	return ok && matchElems(pattern[1:], name[1:])
+}
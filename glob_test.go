@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testRoot creates a temporary root directory with the given files.
+func testRoot(t *testing.T, files ...string) string {
+	root, err := ioutil.TempDir("", "goftp")
+	assert.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(root) })
+	for _, name := range files {
+		path := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		assert.NoError(t, ioutil.WriteFile(path, []byte(name), 0644))
+	}
+	return root
+}
+
+func TestExpand(t *testing.T) {
+	root := testRoot(t, "a.csv", "b.csv", ".h.csv", "logs/2026-01.log", "logs/2026-02.log", "logs/x/deep.csv", "lit*")
+
+	cases := []struct {
+		pattern string
+		currdir string
+		matches []string
+		err     string
+	}{
+		{"*.csv", ".", []string{"a.csv", "b.csv"}, ""},
+		{".*.csv", ".", []string{".h.csv"}, ""},
+		{"[ab].csv", ".", []string{"a.csv", "b.csv"}, ""},
+		{"logs/2026-0?.log", ".", []string{"logs/2026-01.log", "logs/2026-02.log"}, ""},
+		{"**/*.csv", ".", []string{"a.csv", "b.csv", "logs/x/deep.csv"}, ""},
+		{"/logs/*/*.csv", "logs", []string{"/logs/x/deep.csv"}, ""},
+		{"../*.csv", "logs", []string{"../a.csv", "../b.csv"}, ""},
+		{"lit*", ".", []string{"lit*"}, ""},
+		{"*.txt", ".", nil, "no match for *.txt\n"},
+		{"../../*", "logs", nil, "路径权限不够!\n"},
+	}
+	for _, c := range cases {
+		s := &Session{Root: root, Dir: c.currdir, FS: localDriver{}}
+		matches, err := s.expand(c.pattern)
+		if c.err != "" {
+			assert.EqualError(t, err, c.err, c.pattern)
+			continue
+		}
+		assert.NoError(t, err, c.pattern)
+		assert.Equal(t, c.matches, matches, c.pattern)
+	}
+
+	// quoted patterns are names
+	s := &Session{Root: root, Dir: ".", FS: localDriver{}, literal: map[string]bool{"*.csv": true}}
+	matches, err := s.expand("*.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"*.csv"}, matches)
+
+	max := option.MaxGlobMatches
+	option.MaxGlobMatches = 1
+	defer func() { option.MaxGlobMatches = max }()
+	_, err = (&Session{Root: root, Dir: ".", FS: localDriver{}}).expand("*.csv")
+	assert.EqualError(t, err, "*.csv matches more than 1 files\n")
+}
+
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"/in/*.csv", "/in/a.csv", true},
+		{"in/*.csv", "/in/a.csv", true},
+		{"/in/*.csv", "/in/x/a.csv", false},
+		{"/in/**/*.csv", "/in/a.csv", true},
+		{"/in/**/*.csv", "/in/x/y/a.csv", true},
+		{"/**", "/", true},
+		{"/**", "/a/b", true},
+		{"/in/*", "/out/a", false},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.match, matchPath(c.pattern, c.name), c.pattern+" "+c.name)
+	}
+}
@@ -0,0 +1,20 @@
+module github.com/moshuipan/goftp
+
+go 1.25.0
+
+require (
+	github.com/klauspost/compress v1.18.0
+	github.com/pkg/sftp v1.13.9
+	github.com/stretchr/testify v1.9.0
+	golang.org/x/crypto v0.54.0
+	golang.org/x/net v0.57.0
+	golang.org/x/text v0.40.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/kr/fs v0.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.47.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
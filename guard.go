@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxLoginDelay caps the pause after a failed login.
+const maxLoginDelay = 30 * time.Second
+
+// loginGuard slows down clients which keep failing to log in and bans their
+// IPs for a while. Failures are forgotten BanTime after the last one.
+type loginGuard struct {
+	mu    sync.Mutex
+	ips   map[string]*failures
+	users map[string]*failures
+	bans  map[string]*Ban
+	// swept is when the expired entries were dropped last, see expire.
+	swept time.Time
+}
+
+type failures struct {
+	count int
+	last  time.Time
+	// ready is when the answer to the last failure of an IP goes out.
+	ready time.Time
+}
+
+// Ban is a banned client address.
+type Ban struct {
+	IP       string    `json:"ip"`
+	Until    time.Time `json:"until"`
+	Failures int       `json:"failures"`
+}
+
+var guard = newLoginGuard()
+
+func newLoginGuard() *loginGuard {
+	return &loginGuard{
+		ips:   make(map[string]*failures),
+		users: make(map[string]*failures),
+		bans:  make(map[string]*Ban),
+	}
+}
+
+// banned tells whether ip is banned and until when.
+func (g *loginGuard) banned(ip string) (time.Time, bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	b, ok := g.bans[ip]
+	if !ok {
+		return time.Time{}, false
+	}
+	if time.Now().After(b.Until) {
+		delete(g.bans, ip)
+		return time.Time{}, false
+	}
+	return b.Until, true
+}
+
+// failed counts a failed login of user from ip and returns how long to wait
+// before answering. The answers to an IP are queued one delay after the
+// other, so parallel connections are not faster than one. The IP is banned
+// once it reaches MaxLoginFailures.
+func (g *loginGuard) failed(s *Session, ip, user string) time.Duration {
+	g.mu.Lock()
+	now := time.Now()
+	g.expire(now)
+	n := count(g.ips, ip, now)
+	if m := count(g.users, user, now); m > n {
+		n = m
+	}
+	delay := option.LoginDelay
+	for i := 1; i < n && delay < maxLoginDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxLoginDelay {
+		delay = maxLoginDelay
+	}
+	f := g.ips[ip]
+	if f.ready.After(now) {
+		delay += f.ready.Sub(now)
+	}
+	f.ready = now.Add(delay)
+	var ban *Ban
+	if option.MaxLoginFailures > 0 && g.ips[ip].count >= option.MaxLoginFailures {
+		ban = &Ban{IP: ip, Until: now.Add(option.BanTime), Failures: g.ips[ip].count}
+		g.bans[ip] = ban
+		delete(g.ips, ip)
+	}
+	g.mu.Unlock()
+	if ban != nil {
+		audit(s, "ban", ip, "until "+ban.Until.Format(time.RFC3339))
+	}
+	return delay
+}
+
+// expire drops the failures older than BanTime and the bans which ended, so
+// that clients trying many names or addresses do not fill the maps. It looks
+// at the entries at most once every BanTime, g.mu is held.
+func (g *loginGuard) expire(now time.Time) {
+	if now.Sub(g.swept) < option.BanTime {
+		return
+	}
+	g.swept = now
+	for _, m := range []map[string]*failures{g.ips, g.users} {
+		for key, f := range m {
+			if now.Sub(f.last) > option.BanTime {
+				delete(m, key)
+			}
+		}
+	}
+	for ip, b := range g.bans {
+		if now.After(b.Until) {
+			delete(g.bans, ip)
+		}
+	}
+}
+
+// count adds a failure to m[key] and returns the number of recent failures.
+func count(m map[string]*failures, key string, now time.Time) int {
+	f, ok := m[key]
+	if !ok || now.Sub(f.last) > option.BanTime {
+		f = &failures{}
+		m[key] = f
+	}
+	f.count++
+	f.last = now
+	return f.count
+}
+
+// succeeded forgets the failures of ip and user.
+func (g *loginGuard) succeeded(ip, user string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	delete(g.ips, ip)
+	delete(g.users, user)
+}
+
+// Bans returns the current bans, sorted by IP.
+func (g *loginGuard) Bans() []Ban {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	now := time.Now()
+	var list []Ban
+	for ip, b := range g.bans {
+		if now.After(b.Until) {
+			delete(g.bans, ip)
+			continue
+		}
+		list = append(list, *b)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].IP < list[j].IP })
+	return list
+}
+
+// Unban lifts the ban of ip and reports whether there was one.
+func (g *loginGuard) Unban(ip string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, ok := g.bans[ip]
+	delete(g.bans, ip)
+	delete(g.ips, ip)
+	return ok
+}
+
+// bans lists the banned IPs.
+func bans(s *Session, args []string) (out Buffer) {
+	//bans
+	if !s.isAdmin() {
+		out.Write([]byte("permission denied\n"))
+		return
+	}
+	var buf bytes.Buffer
+	for _, b := range guard.Bans() {
+		fmt.Fprintf(&buf, "%s until %s after %d failures\n", b.IP, b.Until.Format(time.RFC3339), b.Failures)
+	}
+	out.Write(buf.Bytes())
+	return
+}
+
+// unban lifts the ban of an IP.
+func unban(s *Session, args []string) error {
+	//unban ip
+	if !s.isAdmin() {
+		return errors.New("permission denied\n")
+	}
+	if len(args) != 2 {
+		return errors.New("unban ip\n")
+	}
+	if !guard.Unban(args[1]) {
+		return errors.New(args[1] + " is not banned\n")
+	}
+	audit(s, "unban", args[1])
+	return nil
+}
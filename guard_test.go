@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoginGuard(t *testing.T) {
+	saved := *option
+	defer func() { *option = saved }()
+	option.MaxLoginFailures = 3
+	option.LoginDelay = time.Second
+	option.BanTime = time.Minute
+
+	g := newLoginGuard()
+	s := &Session{}
+	assert.Equal(t, time.Second, g.failed(s, "192.0.2.1", "bob"))
+	// a parallel failure from the IP waits for the answer to the first.
+	assert.InDelta(t, 3*time.Second, g.failed(s, "192.0.2.1", "bob"), float64(100*time.Millisecond))
+	// the user keeps its failures when the IP changes.
+	assert.Equal(t, 4*time.Second, g.failed(s, "192.0.2.2", "bob"))
+	_, ok := g.banned("192.0.2.1")
+	assert.False(t, ok)
+
+	g.failed(s, "192.0.2.1", "alice")
+	until, ok := g.banned("192.0.2.1")
+	assert.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(time.Minute), until, time.Second)
+	bans := g.Bans()
+	assert.Len(t, bans, 1)
+	assert.Equal(t, "192.0.2.1", bans[0].IP)
+	assert.Equal(t, 3, bans[0].Failures)
+
+	assert.True(t, g.Unban("192.0.2.1"))
+	assert.False(t, g.Unban("192.0.2.1"))
+	_, ok = g.banned("192.0.2.1")
+	assert.False(t, ok)
+
+	g.succeeded("192.0.2.2", "bob")
+	assert.Equal(t, time.Second, g.failed(s, "192.0.2.2", "bob"))
+
+	for i := 0; i < 10; i++ {
+		g.failed(s, "192.0.2.3", "carol")
+	}
+	assert.Equal(t, maxLoginDelay, g.failed(s, "192.0.2.4", "carol"))
+}
+
+func TestLoginGuardExpire(t *testing.T) {
+	saved := *option
+	defer func() { *option = saved }()
+	option.MaxLoginFailures = 2
+	option.LoginDelay = 0
+	option.BanTime = time.Minute
+
+	g := newLoginGuard()
+	s := &Session{}
+	for _, user := range []string{"a", "b", "c"} {
+		g.failed(s, "192.0.2."+user, user)
+	}
+	g.failed(s, "192.0.2.a", "a")
+	assert.Len(t, g.ips, 2)
+	assert.Len(t, g.users, 3)
+	assert.Len(t, g.bans, 1)
+
+	// an hour later only the new failure is left.
+	past := time.Now().Add(-time.Hour)
+	for _, m := range []map[string]*failures{g.ips, g.users} {
+		for _, f := range m {
+			f.last = past
+		}
+	}
+	g.bans["192.0.2.a"].Until = past
+	g.swept = past
+	g.failed(s, "192.0.2.d", "d")
+	assert.Equal(t, []string{"192.0.2.d"}, failureKeys(g.ips))
+	assert.Equal(t, []string{"d"}, failureKeys(g.users))
+	assert.Empty(t, g.bans)
+
+	// the maps are not swept again within BanTime.
+	g.users["d"].last = past
+	g.failed(s, "192.0.2.e", "e")
+	assert.Len(t, g.users, 2)
+}
+
+func failureKeys(m map[string]*failures) []string {
+	var list []string
+	for key := range m {
+		list = append(list, key)
+	}
+	return list
+}
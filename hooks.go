@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Names of the events, as used by notifications and the audit log.
+const (
+	EventLogin        = "login"
+	EventUpload       = "upload"
+	EventUploadFailed = "upload-failed"
+	EventDownload     = "download"
+	EventDelete       = "delete"
+	EventRename       = "rename"
+	EventDisconnect   = "disconnect"
+)
+
+// FileEvent describes the file an event is about.
+type FileEvent struct {
+	// Path is relative to the session root and starts with "/".
+	Path string
+	// To is the new path of a rename.
+	To      string
+	Size    int64
+	ModTime time.Time
+}
+
+// Hooks are called when something happens in a session.
+//
+// Synchronous hooks run in the goroutine of the session before the action
+// takes place, and an error vetoes it: the client gets the error instead.
+// OnUpload is called once the data is received but before the file is moved
+// into place, so a veto discards it. Asynchronous hooks run in their own
+// goroutine after the action succeeded, their errors are only logged.
+// OnUploadFailed and OnDisconnect cannot veto anything.
+type Hooks interface {
+	OnLogin(s *Session) error
+	OnUpload(s *Session, f FileEvent) error
+	OnUploadFailed(s *Session, f FileEvent, err error)
+	OnDownload(s *Session, f FileEvent) error
+	OnDelete(s *Session, f FileEvent) error
+	OnRename(s *Session, f FileEvent) error
+	OnDisconnect(s *Session)
+}
+
+// NopHooks does nothing. Embed it to implement only some of the Hooks.
+type NopHooks struct{}
+
+func (NopHooks) OnLogin(s *Session) error                          { return nil }
+func (NopHooks) OnUpload(s *Session, f FileEvent) error            { return nil }
+func (NopHooks) OnUploadFailed(s *Session, f FileEvent, err error) {}
+func (NopHooks) OnDownload(s *Session, f FileEvent) error          { return nil }
+func (NopHooks) OnDelete(s *Session, f FileEvent) error            { return nil }
+func (NopHooks) OnRename(s *Session, f FileEvent) error            { return nil }
+func (NopHooks) OnDisconnect(s *Session)                           {}
+
+type hook struct {
+	Hooks
+	async bool
+}
+
+var hooks struct {
+	sync.RWMutex
+	list []hook
+}
+
+// RegisterHooks adds h to the hooks called on every session. Synchronous
+// hooks are called in the order they were registered.
+func RegisterHooks(h Hooks, async bool) {
+	hooks.Lock()
+	defer hooks.Unlock()
+	hooks.list = append(hooks.list, hook{h, async})
+}
+
+func registeredHooks() []hook {
+	hooks.RLock()
+	defer hooks.RUnlock()
+	return hooks.list
+}
+
+// check calls the synchronous hooks, the first error vetoes the action.
+func check(call func(h Hooks) error) error {
+	for _, h := range registeredHooks() {
+		if h.async {
+			continue
+		}
+		if err := call(h.Hooks); err != nil {
+			return errors.New(strings.TrimSuffix(err.Error(), "\n") + "\n")
+		}
+	}
+	return nil
+}
+
+// notify calls the asynchronous hooks once the action is done.
+func notify(call func(h Hooks) error) {
+	for _, h := range registeredHooks() {
+		if !h.async {
+			continue
+		}
+		go func(h Hooks) {
+			if err := call(h); err != nil {
+				fmt.Println("hook error!", err)
+			}
+		}(h.Hooks)
+	}
+}
+
+// uploadFailed tells all hooks that an upload to f failed with err.
+func uploadFailed(s *Session, f FileEvent, err error) {
+	for _, h := range registeredHooks() {
+		if h.async {
+			go h.OnUploadFailed(s, f, err)
+		} else {
+			h.OnUploadFailed(s, f, err)
+		}
+	}
+}
+
+// disconnected tells all hooks that the client of s is gone.
+func disconnected(s *Session) {
+	for _, h := range registeredHooks() {
+		if h.async {
+			go h.OnDisconnect(s)
+		} else {
+			h.OnDisconnect(s)
+		}
+	}
+}
+
+// fileEvent describes the host file path as seen by the client of s.
+func (s *Session) fileEvent(path string, fi os.FileInfo) FileEvent {
+	f := FileEvent{Path: s.clientPath(path)}
+	if fi != nil {
+		f.Size, f.ModTime = fi.Size(), fi.ModTime()
+	}
+	return f
+}
+
+// clientPath returns the host path relative to the root of s, starting with "/".
+func (s *Session) clientPath(path string) string {
+	rel, err := filepath.Rel(s.Root, path)
+	if err != nil || rel == "." {
+		return "/"
+	}
+	return "/" + filepath.ToSlash(rel)
+}
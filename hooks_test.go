@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type testHooks struct {
+	NopHooks
+	name   string
+	calls  chan string
+	vetoes bool
+}
+
+func (h *testHooks) OnUpload(s *Session, f FileEvent) error {
+	h.calls <- h.name + " " + f.Path
+	if h.vetoes {
+		return errors.New("rejected by " + h.name)
+	}
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	defer func() { hooks.list = nil }()
+	calls := make(chan string, 10)
+	RegisterHooks(&testHooks{name: "sync1", calls: calls}, false)
+	RegisterHooks(&testHooks{name: "async", calls: calls}, true)
+	RegisterHooks(&testHooks{name: "sync2", calls: calls, vetoes: true}, false)
+	RegisterHooks(&testHooks{name: "sync3", calls: calls}, false)
+
+	s := &Session{Root: "/srv"}
+	event := s.fileEvent(filepath.Join("/srv", "in", "a.csv"), nil)
+	err := check(func(h Hooks) error { return h.OnUpload(s, event) })
+	assert.EqualError(t, err, "rejected by sync2\n")
+	assert.Equal(t, "sync1 /in/a.csv", <-calls)
+	assert.Equal(t, "sync2 /in/a.csv", <-calls)
+	assert.Len(t, calls, 0)
+
+	notify(func(h Hooks) error { return h.OnUpload(s, event) })
+	assert.Equal(t, "async /in/a.csv", <-calls)
+}
+
+func TestClientPath(t *testing.T) {
+	s := &Session{Root: "/srv"}
+	assert.Equal(t, "/", s.clientPath("/srv"))
+	assert.Equal(t, "/.h", s.clientPath("/srv/.h"))
+	assert.Equal(t, "/a/b", s.clientPath("/srv/a/b"))
+}
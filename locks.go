@@ -0,0 +1,219 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Lock policies.
+const (
+	LockReject = "reject"
+	LockWait   = "wait"
+)
+
+// lockHolder is a session holding a lock.
+type lockHolder struct {
+	Session uint64
+	User    string
+	Write   bool
+	Since   time.Time
+}
+
+// fileLock is the lock of one path: many readers or one writer.
+type fileLock struct {
+	holders []*lockHolder
+	// writers is the number of writers waiting for the lock, readers wait
+	// behind them.
+	writers int
+	// changed is closed whenever a holder or a waiting writer lets go.
+	changed chan struct{}
+}
+
+// exclusive reports whether l is held by a writer or a writer waits for it.
+func (l *fileLock) exclusive() bool {
+	return l.writers > 0 || len(l.holders) > 0 && l.holders[0].Write
+}
+
+// lockManager hands out the locks of the paths sessions transfer, so two
+// uploads never write the same file and nobody reads a file while it is
+// replaced. A directory locked for writing, as by rm -r, covers everything
+// below it.
+type lockManager struct {
+	mu    sync.Mutex
+	files map[string]*fileLock
+}
+
+var locks = &lockManager{files: map[string]*fileLock{}}
+
+// lock locks the host path name for s, exclusively if write. A busy path fails
+// right away with the reject policy and after option.LockTimeout with the wait
+// policy, where a waiting writer goes before readers arriving after it. The
+// returned func releases the lock.
+func (m *lockManager) lock(s *Session, name string, write bool) (func(), error) {
+	h := &lockHolder{Session: s.ID, User: s.User, Write: write}
+	var timeout <-chan time.Time
+	waiting, timedOut := false, false
+	for {
+		m.mu.Lock()
+		l := m.files[name]
+		if l == nil {
+			l = &fileLock{changed: make(chan struct{})}
+			m.files[name] = l
+		}
+		b := m.blocker(name, l, write)
+		if b == nil || option.LockPolicy != LockWait || timedOut {
+			if waiting {
+				l.writers--
+			}
+			if b != nil {
+				m.release(name, l)
+				m.mu.Unlock()
+				return nil, errors.New(s.clientPath(name) + " is busy\n")
+			}
+			h.Since = time.Now()
+			l.holders = append(l.holders, h)
+			m.mu.Unlock()
+			return func() { m.unlock(name, h) }, nil
+		}
+		if write && !waiting {
+			l.writers++
+			waiting = true
+		}
+		changed := b.changed
+		m.mu.Unlock()
+		if timeout == nil {
+			timeout = time.After(option.LockTimeout)
+		}
+		select {
+		case <-changed:
+		case <-timeout:
+			// one last look, the lock might have become free just now.
+			timedOut = true
+		}
+	}
+}
+
+// blocker returns the lock which keeps s from locking name, whose lock is l,
+// or nil: a holder of l, a waiting writer of l for readers, a writer of a
+// directory above name, or for writers a holder of a path below name.
+func (m *lockManager) blocker(name string, l *fileLock, write bool) *fileLock {
+	if write && len(l.holders) > 0 || !write && l.exclusive() {
+		return l
+	}
+	for dir := filepath.Dir(name); ; dir = filepath.Dir(dir) {
+		if d := m.files[dir]; d != nil && d.exclusive() {
+			return d
+		}
+		if dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	if write {
+		prefix := name + string(filepath.Separator)
+		for path, d := range m.files {
+			if len(d.holders) > 0 && strings.HasPrefix(path, prefix) {
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// lockBoth locks a and b for s like lock, in the order of their names, so
+// two sessions locking the same paths never wait for each other.
+func (m *lockManager) lockBoth(s *Session, a string, writeA bool, b string, writeB bool) (func(), error) {
+	if a == b {
+		return m.lock(s, a, writeA || writeB)
+	}
+	if b < a {
+		a, b, writeA, writeB = b, a, writeB, writeA
+	}
+	unlockA, err := m.lock(s, a, writeA)
+	if err != nil {
+		return nil, err
+	}
+	unlockB, err := m.lock(s, b, writeB)
+	if err != nil {
+		unlockA()
+		return nil, err
+	}
+	return func() {
+		unlockB()
+		unlockA()
+	}, nil
+}
+
+func (m *lockManager) unlock(name string, h *lockHolder) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l := m.files[name]
+	if l == nil {
+		return
+	}
+	for i, v := range l.holders {
+		if v == h {
+			l.holders = append(l.holders[:i], l.holders[i+1:]...)
+			break
+		}
+	}
+	m.release(name, l)
+}
+
+// release wakes up the sessions waiting for l, the lock of name, and forgets
+// it once nobody holds or waits for it.
+func (m *lockManager) release(name string, l *fileLock) {
+	close(l.changed)
+	l.changed = make(chan struct{})
+	if len(l.holders) == 0 && l.writers == 0 {
+		delete(m.files, name)
+	}
+}
+
+// Locks returns the holders of all locks by path.
+func (m *lockManager) Locks() map[string][]lockHolder {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list := make(map[string][]lockHolder, len(m.files))
+	for name, l := range m.files {
+		for _, h := range l.holders {
+			list[name] = append(list[name], *h)
+		}
+	}
+	return list
+}
+
+func lockList(s *Session, args []string) (out Buffer) {
+	//locks
+	if !s.isAdmin() {
+		out.Write([]byte("permission denied\n"))
+		return
+	}
+	list := locks.Locks()
+	names := make([]string, 0, len(list))
+	for name := range list {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var buf bytes.Buffer
+	for _, name := range names {
+		path := name
+		if rel, err := filepath.Rel(Root, name); err == nil {
+			path = "/" + filepath.ToSlash(rel)
+		}
+		for _, h := range list[name] {
+			mode := "read"
+			if h.Write {
+				mode = "write"
+			}
+			fmt.Fprintf(&buf, "%s %s session %d %s since %s\n", path, mode, h.Session, h.User, h.Since.Format(time.RFC3339))
+		}
+	}
+	out.Write(buf.Bytes())
+	return
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLocks(t *testing.T) {
+	oldRoot, oldPolicy, oldTimeout := Root, option.LockPolicy, option.LockTimeout
+	defer func() { Root, option.LockPolicy, option.LockTimeout = oldRoot, oldPolicy, oldTimeout }()
+	Root = "/srv"
+	option.LockPolicy = LockReject
+	m := &lockManager{files: map[string]*fileLock{}}
+	a := &Session{ID: 1, User: "alice", Root: Root}
+	b := &Session{ID: 2, User: "bob", Root: Root}
+	name := filepath.Join(Root, "in", "a.csv")
+
+	// readers share, a writer waits for all of them
+	r1, err := m.lock(a, name, false)
+	assert.NoError(t, err)
+	r2, err := m.lock(b, name, false)
+	assert.NoError(t, err)
+	_, err = m.lock(b, name, true)
+	assert.EqualError(t, err, "/in/a.csv is busy\n")
+	assert.Len(t, m.Locks()[name], 2)
+	r1()
+	r2()
+	assert.Empty(t, m.Locks())
+
+	// a writer excludes everybody
+	w, err := m.lock(a, name, true)
+	assert.NoError(t, err)
+	_, err = m.lock(b, name, false)
+	assert.Error(t, err)
+	_, err = m.lock(a, filepath.Join(Root, "other"), true)
+	assert.NoError(t, err, "other paths are not affected")
+
+	option.LockPolicy, option.LockTimeout = LockWait, 50*time.Millisecond
+	start := time.Now()
+	_, err = m.lock(b, name, true)
+	assert.Error(t, err)
+	assert.True(t, time.Since(start) >= option.LockTimeout)
+
+	option.LockTimeout = 5 * time.Second
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		w()
+	}()
+	unlock, err := m.lock(b, name, false)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), m.Locks()[name][0].Session)
+	unlock()
+}
+
+func TestLockOrder(t *testing.T) {
+	oldRoot, oldPolicy, oldTimeout := Root, option.LockPolicy, option.LockTimeout
+	defer func() { Root, option.LockPolicy, option.LockTimeout = oldRoot, oldPolicy, oldTimeout }()
+	Root = "/srv"
+	option.LockPolicy, option.LockTimeout = LockWait, 5*time.Second
+	m := &lockManager{files: map[string]*fileLock{}}
+	a := &Session{ID: 1, User: "alice", Root: Root}
+	b := &Session{ID: 2, User: "bob", Root: Root}
+	x, y := filepath.Join(Root, "x"), filepath.Join(Root, "y")
+
+	// opposing copies take their locks in the same order
+	done := make(chan error)
+	for _, s := range []*Session{a, b} {
+		go func(s *Session) {
+			for i := 0; i < 200; i++ {
+				dst, src := x, y
+				if s == b {
+					dst, src = y, x
+				}
+				unlock, err := m.lockBoth(s, dst, true, src, false)
+				if err != nil {
+					done <- err
+					return
+				}
+				unlock()
+			}
+			done <- nil
+		}(s)
+	}
+	assert.NoError(t, <-done)
+	assert.NoError(t, <-done)
+	assert.Empty(t, m.files)
+
+	// a waiting writer goes before readers arriving after it
+	r1, err := m.lock(a, x, false)
+	assert.NoError(t, err)
+	order := make(chan string, 2)
+	take := func(s *Session, write bool, what string) {
+		unlock, err := m.lock(s, x, write)
+		if assert.NoError(t, err) {
+			order <- what
+			time.Sleep(10 * time.Millisecond)
+			unlock()
+		}
+	}
+	go take(b, true, "writer")
+	for waiting := 0; waiting == 0; time.Sleep(time.Millisecond) {
+		m.mu.Lock()
+		waiting = m.files[x].writers
+		m.mu.Unlock()
+	}
+	go take(a, false, "reader")
+	time.Sleep(10 * time.Millisecond)
+	r1()
+	assert.Equal(t, "writer", <-order)
+	assert.Equal(t, "reader", <-order)
+	assert.Eventually(t, func() bool { return len(m.Locks()) == 0 }, time.Second, time.Millisecond)
+
+	// a directory locked for writing covers the paths below it
+	option.LockPolicy = LockReject
+	dir := filepath.Join(Root, "dir")
+	r, err := m.lock(a, filepath.Join(dir, "sub", "f"), false)
+	assert.NoError(t, err)
+	_, err = m.lock(b, dir, true)
+	assert.EqualError(t, err, "/dir is busy\n")
+	r()
+	w, err := m.lock(b, dir, true)
+	assert.NoError(t, err)
+	_, err = m.lock(a, filepath.Join(dir, "f"), false)
+	assert.EqualError(t, err, "/dir/f is busy\n")
+	w()
+	assert.Empty(t, m.files)
+}
+
+func TestLockList(t *testing.T) {
+	oldRoot := Root
+	defer func() { Root = oldRoot }()
+	Root = "/srv"
+	s := &Session{ID: 7, User: "root", Root: Root, account: &User{Admin: true}}
+	unlock, err := locks.lock(s, filepath.Join(Root, "big.iso"), true)
+	assert.NoError(t, err)
+	defer unlock()
+	out := string(lockList(s, []string{"locks"}))
+	assert.True(t, strings.HasPrefix(out, "/big.iso write session 7 root since "), out)
+	assert.Equal(t, "permission denied\n", string(lockList(&Session{}, []string{"locks"})))
+}
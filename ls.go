@@ -0,0 +1,316 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/moshuipan/goftp/flag"
+)
+
+// lsOpts are the options of ls.
+type lsOpts struct {
+	long      bool // -l
+	all       bool // -a
+	human     bool // -h
+	byTime    bool // -t
+	bySize    bool // -S
+	recursive bool // -R
+	json      bool // -j
+}
+
+// ls lists directories like the Unix ls.
+func ls(s *Session, args []string) (out Buffer) {
+	//ls [-lahtSRj] [dir...]
+	var opts lsOpts
+	var dirs []string
+	for _, v := range args[1:] {
+		if len(v) < 2 || v[0] != '-' {
+			dirs = append(dirs, v)
+			continue
+		}
+		for _, c := range v[1:] {
+			switch c {
+			case 'l':
+				opts.long = true
+			case 'a':
+				opts.all = true
+			case 'h':
+				opts.human = true
+			case 't':
+				opts.byTime = true
+			case 'S':
+				opts.bySize = true
+			case 'R':
+				opts.recursive = true
+			case 'j':
+				opts.json = true
+			default:
+				out.Write([]byte("ls: unknown option -" + string(c) + "\n"))
+				return
+			}
+		}
+	}
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	var expanded []string
+	for _, dir := range dirs {
+		names, err := s.expand(dir)
+		if err != nil {
+			out.Write([]byte(err.Error()))
+			return
+		}
+		expanded = append(expanded, names...)
+	}
+	dirs = expanded
+	if opts.json {
+		return lsJSON(s, dirs, opts)
+	}
+	for i, dir := range dirs {
+		path, err := s.resolve(dir)
+		if err != nil {
+			out.Write([]byte(err.Error()))
+			continue
+		}
+		fi, err := s.FS.Lstat(path)
+		if err != nil {
+			out.Write([]byte("read dir error!\n"))
+			continue
+		}
+		if !fi.IsDir() {
+			// files are listed under the name they were given.
+			out.Write([]byte(listEntries(s.FS, filepath.Dir(path), []os.FileInfo{namedInfo{fi, dir}}, opts)))
+			continue
+		}
+		if len(dirs) > 1 || opts.recursive {
+			if i > 0 {
+				out.Write([]byte("\n"))
+			}
+			out.Write([]byte(dir + ":\n"))
+		}
+		if err := listDir(s.FS, &out, path, dir, opts); err != nil {
+			out.Write([]byte(err.Error()))
+		}
+	}
+	return
+}
+
+// listDir writes the listing of path to out, and of its subdirectories for -R.
+// name is path as the client knows it.
+func listDir(fs Driver, out *Buffer, path, name string, opts lsOpts) error {
+	entries, err := readEntries(fs, path, opts)
+	if err != nil {
+		return err
+	}
+	out.Write([]byte(listEntries(fs, path, entries, opts)))
+	if !opts.recursive {
+		return nil
+	}
+	for _, v := range entries {
+		// symlinks are not followed, they might lead out of the root.
+		if !v.IsDir() {
+			continue
+		}
+		sub := filepath.Join(name, v.Name())
+		out.Write([]byte("\n" + sub + ":\n"))
+		if err := listDir(fs, out, filepath.Join(path, v.Name()), sub, opts); err != nil {
+			out.Write([]byte(err.Error()))
+		}
+	}
+	return nil
+}
+
+// readEntries reads the entries of the directory path, filtered and sorted as opts say.
+func readEntries(fs Driver, path string, opts lsOpts) ([]os.FileInfo, error) {
+	f, err := readDir(fs, path)
+	if err != nil {
+		return nil, errors.New("read dir error!\n")
+	}
+	entries := make([]os.FileInfo, 0, len(f))
+	for _, v := range f {
+		if opts.all || !strings.HasPrefix(v.Name(), ".") {
+			entries = append(entries, v)
+		}
+	}
+	switch {
+	case opts.byTime:
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].ModTime().After(entries[j].ModTime())
+		})
+	case opts.bySize:
+		sort.SliceStable(entries, func(i, j int) bool {
+			return entries[i].Size() > entries[j].Size()
+		})
+	}
+	return entries, nil
+}
+
+// lsJSON lists dirs as a single JSON array. Names are relative to the listed
+// directory when only one is given, and prefixed with the directory otherwise.
+func lsJSON(s *Session, dirs []string, opts lsOpts) (out Buffer) {
+	list := []fileFacts{}
+	var walk func(path, prefix string) error
+	walk = func(path, prefix string) error {
+		entries, err := readEntries(s.FS, path, opts)
+		if err != nil {
+			return err
+		}
+		list = append(list, jsonList(s.FS, path, prefix, entries)...)
+		if opts.recursive {
+			for _, v := range entries {
+				if v.IsDir() {
+					if err := walk(filepath.Join(path, v.Name()), prefix+v.Name()+"/"); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	}
+	for _, dir := range dirs {
+		path, err := s.resolve(dir)
+		if err != nil {
+			out.Write([]byte(err.Error()))
+			return
+		}
+		fi, err := s.FS.Lstat(path)
+		if err != nil {
+			out.Write([]byte("read dir error!\n"))
+			return
+		}
+		if !fi.IsDir() {
+			f := factsOf(s.FS, filepath.Dir(path), fi)
+			f.Name = dir
+			list = append(list, f)
+			continue
+		}
+		prefix := ""
+		if len(dirs) > 1 {
+			prefix = strings.TrimSuffix(dir, "/") + "/"
+		}
+		if err := walk(path, prefix); err != nil {
+			out.Write([]byte(err.Error()))
+			return
+		}
+	}
+	writeJSON(&out, list)
+	return
+}
+
+// listEntries formats the entries of dir, one per line.
+func listEntries(fs Driver, dir string, entries []os.FileInfo, opts lsOpts) string {
+	if !opts.long {
+		var b strings.Builder
+		for _, v := range entries {
+			b.WriteString(v.Name() + "\n")
+		}
+		return b.String()
+	}
+	if len(entries) == 0 {
+		return ""
+	}
+	owners := map[uint32]string{}
+	groups := map[uint32]string{}
+	t := flag.NewTable(1 << 16)
+	for _, v := range entries {
+		links, owner, group := fileOwner(v, owners, groups)
+		size := fmt.Sprint(v.Size())
+		if opts.human {
+			size = humanSize(v.Size())
+		}
+		name := v.Name()
+		if v.Mode()&os.ModeSymlink != 0 {
+			if target, err := fs.Readlink(filepath.Join(dir, filepath.Base(v.Name()))); err == nil {
+				name += " -> " + target
+			}
+		}
+		t.AddRow(fileMode(v.Mode()), links, owner, group, size, modTime(v.ModTime()), name)
+	}
+	lines := strings.Split(strings.TrimSuffix(t.String(), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " ")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// namedInfo is a os.FileInfo with another name.
+type namedInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi namedInfo) Name() string { return fi.name }
+
+// fileMode formats mode like ls, e.g. drwxr-xr-x.
+func fileMode(mode os.FileMode) string {
+	b := []byte("----------")
+	switch {
+	case mode&os.ModeDir != 0:
+		b[0] = 'd'
+	case mode&os.ModeSymlink != 0:
+		b[0] = 'l'
+	case mode&os.ModeNamedPipe != 0:
+		b[0] = 'p'
+	case mode&os.ModeSocket != 0:
+		b[0] = 's'
+	case mode&os.ModeCharDevice != 0:
+		b[0] = 'c'
+	case mode&os.ModeDevice != 0:
+		b[0] = 'b'
+	}
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) != 0 {
+			b[i+1] = rwx[i]
+		}
+	}
+	if mode&os.ModeSetuid != 0 {
+		b[3] = setBit(b[3], 's')
+	}
+	if mode&os.ModeSetgid != 0 {
+		b[6] = setBit(b[6], 's')
+	}
+	if mode&os.ModeSticky != 0 {
+		b[9] = setBit(b[9], 't')
+	}
+	return string(b)
+}
+
+// setBit shows a setuid/setgid/sticky bit on top of the execute bit x.
+func setBit(x byte, c byte) byte {
+	if x == '-' {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// modTime formats t like ls: with the time for the last six months, with the year otherwise.
+func modTime(t time.Time) string {
+	if time.Since(t) < 182*24*time.Hour && time.Until(t) < time.Hour {
+		return t.Format("Jan _2 15:04")
+	}
+	return t.Format("Jan _2  2006")
+}
+
+// humanSize formats size like ls -h, e.g. 912, 4.0K, 13M.
+func humanSize(size int64) string {
+	if size < 1024 {
+		return fmt.Sprint(size)
+	}
+	v := float64(size)
+	for _, unit := range "KMGTPE" {
+		v /= 1024
+		if v < 1024 || unit == 'E' {
+			if v < 10 {
+				return fmt.Sprintf("%.1f%c", v, unit)
+			}
+			return fmt.Sprintf("%.0f%c", v, unit)
+		}
+	}
+	return fmt.Sprint(size)
+}
@@ -0,0 +1,17 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// fileOwner returns the link count, owner and group of a file.
+// They are not known on this platform.
+func fileOwner(fi os.FileInfo, owners, groups map[uint32]string) (uint64, string, string) {
+	return 1, "-", "-"
+}
+
+// fileID returns an identifier which is unique for every file on the host.
+// It is not known on this platform.
+func fileID(fi os.FileInfo) string {
+	return ""
+}
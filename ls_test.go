@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLs(t *testing.T) {
+	root := testRoot(t, "b.txt", "docs/c.txt", "docs/old/d.txt", ".hidden", metaDir+"/trash/x")
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "a.bin"), make([]byte, 10000), 0644))
+	now := time.Now()
+	assert.NoError(t, os.Chtimes(filepath.Join(root, "b.txt"), now, now))
+	assert.NoError(t, os.Chtimes(filepath.Join(root, "a.bin"), now.Add(-time.Hour), now.Add(-time.Hour)))
+	assert.NoError(t, os.Chtimes(filepath.Join(root, "docs"), now.Add(-2*time.Hour), now.Add(-2*time.Hour)))
+	s := &Session{Root: root, Dir: ".", FS: localDriver{}}
+	list := func(args ...string) string {
+		return string(ls(s, append([]string{"ls"}, args...)))
+	}
+
+	// by name, hidden files only with -a, the meta directory never
+	assert.Equal(t, "a.bin\nb.txt\ndocs\n", list())
+	assert.Equal(t, ".hidden\na.bin\nb.txt\ndocs\n", list("-a"))
+	assert.Equal(t, "b.txt\na.bin\ndocs\n", list("-t"))
+	assert.Equal(t, "a.bin\ndocs\nb.txt\n", list("-S"))
+	assert.Equal(t, "ls: unknown option -x\n", list("-x"))
+
+	assert.Equal(t, ".:\na.bin\nb.txt\ndocs\n\ndocs:\nc.txt\nold\n\ndocs/old:\nd.txt\n", list("-R"))
+	assert.Equal(t, "docs:\nc.txt\nold\n\ndocs/old:\nd.txt\n", list("docs", "docs/old"))
+	assert.Equal(t, "b.txt\n", list("b.txt"))
+	assert.NotContains(t, list("-aR"), metaDir)
+	assert.Equal(t, "路径权限不够!\n", list(metaDir))
+
+	long := strings.Split(strings.TrimSpace(list("-lh")), "\n")
+	assert.Len(t, long, 3)
+	assert.True(t, strings.HasPrefix(long[0], "-rw-r--r--"), long[0])
+	assert.Contains(t, long[0], " 9.8K ")
+	assert.True(t, strings.HasSuffix(long[0], " a.bin"), long[0])
+	assert.True(t, strings.HasPrefix(long[2], "d"), long[2])
+
+	var facts []fileFacts
+	assert.NoError(t, json.Unmarshal([]byte(list("-jR", "docs")), &facts))
+	var names []string
+	for _, f := range facts {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"c.txt", "old", "old/d.txt"}, names)
+}
+
+func TestFileMode(t *testing.T) {
+	assert.Equal(t, "drwxr-xr-x", fileMode(os.ModeDir|0755))
+	assert.Equal(t, "lrwxrwxrwx", fileMode(os.ModeSymlink|0777))
+	assert.Equal(t, "-rwsr-xr-x", fileMode(os.ModeSetuid|0755))
+	assert.Equal(t, "-rw-r-Sr--", fileMode(os.ModeSetgid|0644))
+	assert.Equal(t, "drwxrwxrwt", fileMode(os.ModeDir|os.ModeSticky|0777))
+}
+
+func TestHumanSize(t *testing.T) {
+	for size, want := range map[int64]string{
+		912:           "912",
+		1024:          "1.0K",
+		4100:          "4.0K",
+		13 << 20:      "13M",
+		3 << 30:       "3.0G",
+		1<<40 + 1<<39: "1.5T",
+	} {
+		assert.Equal(t, want, humanSize(size), "%d", size)
+	}
+}
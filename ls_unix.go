@@ -0,0 +1,45 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// fileOwner returns the link count, owner and group of a file.
+// owners and groups cache the names already looked up.
+func fileOwner(fi os.FileInfo, owners, groups map[uint32]string) (uint64, string, string) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 1, "-", "-"
+	}
+	owner, ok := owners[st.Uid]
+	if !ok {
+		owner = strconv.FormatUint(uint64(st.Uid), 10)
+		if u, err := user.LookupId(owner); err == nil {
+			owner = u.Username
+		}
+		owners[st.Uid] = owner
+	}
+	group, ok := groups[st.Gid]
+	if !ok {
+		group = strconv.FormatUint(uint64(st.Gid), 10)
+		if g, err := user.LookupGroupId(group); err == nil {
+			group = g.Name
+		}
+		groups[st.Gid] = group
+	}
+	return uint64(st.Nlink), owner, group
+}
+
+// fileID returns an identifier which is unique for every file on the host.
+func fileID(fi os.FileInfo) string {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return ""
+	}
+	return strconv.FormatUint(uint64(st.Dev), 16) + "g" + strconv.FormatUint(uint64(st.Ino), 16)
+}
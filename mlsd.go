@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fileFacts are the RFC 3659 facts of a file, they are also used for JSON listings.
+type fileFacts struct {
+	Name   string    `json:"name"`
+	Type   string    `json:"type"`
+	Size   int64     `json:"size"`
+	Modify time.Time `json:"modify"`
+	Mode   string    `json:"mode"`
+	Perm   string    `json:"perm"`
+	Unique string    `json:"unique,omitempty"`
+	Target string    `json:"target,omitempty"`
+}
+
+// factsOf collects the facts of fi, which is an entry of the directory dir.
+// The permissions follow the mode bits and what the driver allows.
+func factsOf(fs Driver, dir string, fi os.FileInfo) fileFacts {
+	f := fileFacts{
+		Name:   fi.Name(),
+		Type:   "file",
+		Size:   fi.Size(),
+		Modify: fi.ModTime().UTC(),
+		Mode:   fileMode(fi.Mode()),
+		Unique: fileID(fi),
+	}
+	path := filepath.Join(dir, fi.Name())
+	perm := fi.Mode().Perm()
+	if !canWrite(fs, path) {
+		perm &^= 0222
+	}
+	switch {
+	case fi.IsDir():
+		f.Type = "dir"
+		if perm&0100 != 0 {
+			f.Perm += "e"
+		}
+		if perm&0400 != 0 {
+			f.Perm += "l"
+		}
+		if perm&0200 != 0 {
+			f.Perm += "cmp"
+		}
+	case fi.Mode()&os.ModeSymlink != 0:
+		f.Type = "OS.unix=symlink"
+		f.Target, _ = fs.Readlink(path)
+	default:
+		if perm&0400 != 0 {
+			f.Perm += "r"
+		}
+		if perm&0200 != 0 {
+			f.Perm += "aw"
+		}
+	}
+	if parent, err := fs.Stat(dir); err == nil && parent.Mode().Perm()&0200 != 0 && canWrite(fs, dir) && canRemove(fs, path) {
+		f.Perm += "df"
+	}
+	return f
+}
+
+// String formats the facts as a MLSD/MLST line.
+func (f fileFacts) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "type=%s;", f.Type)
+	if f.Type == "file" {
+		fmt.Fprintf(&b, "size=%d;", f.Size)
+	}
+	fmt.Fprintf(&b, "modify=%s;perm=%s;", f.Modify.Format("20060102150405"), f.Perm)
+	if f.Unique != "" {
+		fmt.Fprintf(&b, "unique=%s;", f.Unique)
+	}
+	b.WriteString(" " + f.Name + "\n")
+	return b.String()
+}
+
+// mlsd lists the facts of every entry of a directory.
+func mlsd(s *Session, args []string) (out Buffer) {
+	//mlsd [dir]
+	dir := "."
+	if len(args) > 2 {
+		out.Write([]byte("mlsd [dir]\n"))
+		return
+	}
+	if len(args) == 2 {
+		dir = args[1]
+	}
+	path, err := s.resolve(dir)
+	if err != nil {
+		out.Write([]byte(err.Error()))
+		return
+	}
+	self, err := s.FS.Stat(path)
+	if err != nil || !self.IsDir() {
+		out.Write([]byte("read dir error!\n"))
+		return
+	}
+	f, err := readDir(s.FS, path)
+	if err != nil {
+		out.Write([]byte("read dir error!\n"))
+		return
+	}
+	cdir := factsOf(s.FS, filepath.Dir(path), self)
+	cdir.Type, cdir.Name = "cdir", "."
+	out.Write([]byte(cdir.String()))
+	for _, v := range f {
+		out.Write([]byte(factsOf(s.FS, path, v).String()))
+	}
+	return
+}
+
+// mlst prints the facts of a single file.
+func mlst(s *Session, args []string) (out Buffer) {
+	//mlst [path]
+	name := "."
+	if len(args) > 2 {
+		out.Write([]byte("mlst [path]\n"))
+		return
+	}
+	if len(args) == 2 {
+		name = args[1]
+	}
+	path, err := s.resolve(name)
+	if err != nil {
+		out.Write([]byte(err.Error()))
+		return
+	}
+	fi, err := s.FS.Lstat(path)
+	if err != nil {
+		out.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	f := factsOf(s.FS, filepath.Dir(path), fi)
+	f.Name = name
+	out.Write([]byte(f.String()))
+	return
+}
+
+// jsonList returns the facts of entries of dir for a JSON listing, prefix is prepended to the names.
+func jsonList(fs Driver, dir, prefix string, entries []os.FileInfo) []fileFacts {
+	list := make([]fileFacts, 0, len(entries))
+	for _, v := range entries {
+		f := factsOf(fs, dir, v)
+		f.Name = prefix + f.Name
+		list = append(list, f)
+	}
+	return list
+}
+
+// writeJSON writes v to out as one line of JSON.
+func writeJSON(out *Buffer, v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		out.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	out.Write(append(b, '\n'))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// perms maps the names of an mlsd listing to their perm facts.
+func perms(t *testing.T, listing string) map[string]string {
+	m := map[string]string{}
+	re := regexp.MustCompile(`perm=([a-z]*);.* (.+)$`)
+	for _, line := range strings.Split(strings.TrimSuffix(listing, "\n"), "\n") {
+		match := re.FindStringSubmatch(line)
+		if assert.NotNil(t, match, line) {
+			m[match[2]] = match[1]
+		}
+	}
+	return m
+}
+
+func TestMlsd(t *testing.T) {
+	root := testRoot(t, "a.txt", "docs/b.txt", "ro.txt", metaDir+"/trash/x")
+	assert.NoError(t, os.Chmod(filepath.Join(root, "ro.txt"), 0444))
+	s := &Session{Root: root, Dir: ".", FS: localDriver{}}
+
+	out := string(mlsd(s, []string{"mlsd"}))
+	assert.True(t, strings.HasPrefix(out, "type=cdir;"), out)
+	assert.Contains(t, out, "type=file;size=5;")
+	assert.NotContains(t, out, metaDir)
+	assert.Equal(t, map[string]string{".": "elcmpdf", "a.txt": "rawdf", "docs": "elcmpdf", "ro.txt": "rdf"}, perms(t, out))
+	assert.Equal(t, "read dir error!\n", string(mlsd(s, []string{"mlsd", "a.txt"})))
+
+	out = string(mlst(s, []string{"mlst", "docs/b.txt"}))
+	assert.True(t, strings.HasPrefix(out, "type=file;size=10;"), out)
+	assert.True(t, strings.HasSuffix(out, " docs/b.txt\n"), out)
+}
+
+func TestMlsdReadOnly(t *testing.T) {
+	oldRoot, oldAnon := Root, option.Anonymous
+	defer func() { Root, option.Anonymous = oldRoot, oldAnon }()
+	Root = testRoot(t, "readme.txt", "pub/release.tgz", "pub/incoming/.keep")
+	releases := testRoot(t, "app.tgz")
+	mounts := filepath.Join(testRoot(t), "mounts.json")
+	assert.NoError(t, ioutil.WriteFile(mounts, []byte(`[
+		{"path": "/releases", "dir": "`+releases+`", "read_only": true}
+	]`), 0644))
+	d, err := loadMounts(mounts, localDriver{})
+	assert.NoError(t, err)
+	s := &Session{Root: Root, Dir: ".", FS: d}
+
+	// read-only mounts and their mount points cannot be changed
+	assert.Equal(t, map[string]string{".": "el", "app.tgz": "r"}, perms(t, string(mlsd(s, []string{"mlsd", "releases"}))))
+	list := perms(t, string(mlsd(s, []string{"mlsd"})))
+	delete(list, ".")
+	assert.Equal(t, map[string]string{"pub": "elcmpdf", "readme.txt": "rawdf", "releases": "el"}, list)
+	assert.Equal(t, map[string]string{"releases": "el"}, perms(t, string(mlst(s, []string{"mlst", "releases"}))))
+
+	// neither can the public tree of anonymous sessions
+	option.Anonymous = true
+	s = &Session{Root: Root, Dir: ".", FS: localDriver{}}
+	assert.NoError(t, loginUser(s, []string{"user", "anonymous"}))
+	assert.NoError(t, loginPass(s, []string{"pass", "me@example.com"}))
+	list = perms(t, string(mlsd(s, []string{"mlsd"})))
+	delete(list, ".")
+	assert.Equal(t, map[string]string{"incoming": "el", "release.tgz": "r"}, list)
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	errReadOnly   = errors.New("read-only file system")
+	errMountPoint = errors.New("is a mount point")
+	errCrossMount = errors.New("cannot move between mounts")
+)
+
+// Mount is an entry of the mounts file: the host directory dir appears at the
+// virtual path under the server root.
+type Mount struct {
+	Path     string `json:"path"`
+	Dir      string `json:"dir"`
+	Storage  string `json:"storage,omitempty"`
+	BlobDir  string `json:"blob_dir,omitempty"`
+	ReadOnly bool   `json:"read_only,omitempty"`
+
+	// root is the path the mount has in the namespace, below Root.
+	root string
+	fs   Driver
+}
+
+// loadMounts reads the mounts file path, a JSON array of Mount, and returns
+// the driver of the namespace with the mounts over base.
+func loadMounts(path string, base Driver) (*mountDriver, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var mounts []*Mount
+	if err := json.Unmarshal(data, &mounts); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	d := &mountDriver{base: base}
+	for _, m := range mounts {
+		if err := d.add(m); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+	}
+	return d, nil
+}
+
+// mountDriver dispatches every path to the driver of the mount it is in, the
+// paths outside of all mounts to base. Directories leading to a mount point
+// exist even if base has no such directory.
+type mountDriver struct {
+	base Driver
+	// mounts are sorted by their depth, the deepest first.
+	mounts []*Mount
+}
+
+func (d *mountDriver) add(m *Mount) error {
+	p := filepath.ToSlash(filepath.Clean("/" + m.Path))
+	if p == "/" || m.Path == "" {
+		return fmt.Errorf("mount path %q must name a directory below /", m.Path)
+	}
+	for _, elem := range strings.Split(p, "/") {
+		if elem == metaDir {
+			return fmt.Errorf("mount path %s is reserved", m.Path)
+		}
+	}
+	m.Path = p
+	m.root = filepath.Join(Root, filepath.FromSlash(p))
+	for _, v := range d.mounts {
+		if v.root == m.root {
+			return fmt.Errorf("%s is mounted twice", m.Path)
+		}
+	}
+	dir, err := filepath.Abs(m.Dir)
+	if err != nil {
+		return err
+	}
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("mount %s: %s is no directory", m.Path, m.Dir)
+	}
+	m.Dir = dir
+	switch m.Storage {
+	case "", StorageLocal:
+		m.fs = localDriver{}
+	case StorageDedup:
+		if m.BlobDir == "" {
+			return fmt.Errorf("mount %s: the %s storage needs a blob directory", m.Path, StorageDedup)
+		}
+		if m.fs, err = newDedupDriver(dir, m.BlobDir); err != nil {
+			return fmt.Errorf("mount %s: %v", m.Path, err)
+		}
+	default:
+		return fmt.Errorf("mount %s: unknown storage %s", m.Path, m.Storage)
+	}
+	d.mounts = append(d.mounts, m)
+	sort.SliceStable(d.mounts, func(i, j int) bool {
+		return strings.Count(d.mounts[i].root, string(filepath.Separator)) > strings.Count(d.mounts[j].root, string(filepath.Separator))
+	})
+	return nil
+}
+
+// find returns the mount name is in and the host path of name in it, nil
+// and name for the paths of base.
+func (d *mountDriver) find(name string) (*Mount, string) {
+	for _, m := range d.mounts {
+		if name == m.root {
+			return m, m.Dir
+		}
+		if strings.HasPrefix(name, m.root+string(filepath.Separator)) {
+			return m, filepath.Join(m.Dir, name[len(m.root):])
+		}
+	}
+	return nil, name
+}
+
+// driver returns the driver of name and its path there.
+func (d *mountDriver) driver(name string) (Driver, string) {
+	if m, p := d.find(name); m != nil {
+		return m.fs, p
+	}
+	return d.base, name
+}
+
+// writable returns the driver of name and its path there, unless name is in
+// a read-only mount. op and name make up the error.
+func (d *mountDriver) writable(op, name string) (Driver, string, error) {
+	m, p := d.find(name)
+	if m == nil {
+		return d.base, name, nil
+	}
+	if m.ReadOnly {
+		return nil, "", &os.PathError{Op: op, Path: name, Err: errReadOnly}
+	}
+	return m.fs, p, nil
+}
+
+func (d *mountDriver) readOnly(name string) bool {
+	m, _ := d.find(name)
+	return m != nil && m.ReadOnly
+}
+
+// fixed holds for mount points, the directories leading to them and
+// everything in read-only mounts.
+func (d *mountDriver) fixed(name string) bool {
+	m, _ := d.find(name)
+	return m != nil && (name == m.root || m.ReadOnly) || d.leadsToMount(name)
+}
+
+// leadsToMount reports whether a mount point lies below the directory name.
+func (d *mountDriver) leadsToMount(name string) bool {
+	for _, m := range d.mounts {
+		if strings.HasPrefix(m.root, name+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// mountInfo is the info of a mount point or a directory leading to one,
+// named after its place in the namespace.
+type mountInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi mountInfo) Name() string { return fi.name }
+
+// virtualDir is a directory leading to a mount point which base does not have.
+type virtualDir string
+
+func (fi virtualDir) Name() string       { return string(fi) }
+func (fi virtualDir) Size() int64        { return 0 }
+func (fi virtualDir) Mode() os.FileMode  { return os.ModeDir | 0555 }
+func (fi virtualDir) ModTime() time.Time { return time.Time{} }
+func (fi virtualDir) IsDir() bool        { return true }
+func (fi virtualDir) Sys() interface{}   { return nil }
+
+func (d *mountDriver) stat(name string, lstat bool) (os.FileInfo, error) {
+	m, p := d.find(name)
+	if m != nil && name == m.root {
+		// mount points are always followed
+		fi, err := m.fs.Stat(p)
+		if err != nil {
+			return nil, err
+		}
+		return mountInfo{fi, filepath.Base(name)}, nil
+	}
+	fs, _ := d.driver(name)
+	var fi os.FileInfo
+	var err error
+	if lstat {
+		fi, err = fs.Lstat(p)
+	} else {
+		fi, err = fs.Stat(p)
+	}
+	if os.IsNotExist(err) && d.leadsToMount(name) {
+		return virtualDir(filepath.Base(name)), nil
+	}
+	return fi, err
+}
+
+func (d *mountDriver) Stat(name string) (os.FileInfo, error)  { return d.stat(name, false) }
+func (d *mountDriver) Lstat(name string) (os.FileInfo, error) { return d.stat(name, true) }
+
+// ReadDir reads the directory name, with the mount points and the
+// directories leading to them in place of the entries of the same name.
+func (d *mountDriver) ReadDir(name string) ([]os.FileInfo, error) {
+	fs, p := d.driver(name)
+	entries, err := fs.ReadDir(p)
+	if err != nil && !(os.IsNotExist(err) && d.leadsToMount(name)) {
+		return nil, err
+	}
+	byName := make(map[string]int, len(entries))
+	for i, v := range entries {
+		byName[v.Name()] = i
+	}
+	for _, m := range d.mounts {
+		if !strings.HasPrefix(m.root, name+string(filepath.Separator)) {
+			continue
+		}
+		rel := m.root[len(name)+1:]
+		elem := strings.SplitN(rel, string(filepath.Separator), 2)[0]
+		fi, err := d.Stat(filepath.Join(name, elem))
+		if err != nil {
+			continue
+		}
+		if i, ok := byName[elem]; ok {
+			entries[i] = fi
+			continue
+		}
+		byName[elem] = len(entries)
+		entries = append(entries, fi)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (d *mountDriver) Readlink(name string) (string, error) {
+	fs, p := d.driver(name)
+	return fs.Readlink(p)
+}
+
+func (d *mountDriver) Open(name string) (File, error) {
+	fs, p := d.driver(name)
+	return fs.Open(p)
+}
+
+func (d *mountDriver) Create(name string) (Upload, error) {
+	fs, p, err := d.writable("create", name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.Create(p)
+	if m, _ := d.find(name); err == nil && m != nil {
+		return mountUpload{Upload: f, m: m}, nil
+	}
+	return f, err
+}
+
+// mountUpload is an upload into a mount. Commit returns the name in the
+// namespace rather than in the directory of the mount.
+type mountUpload struct {
+	Upload
+	m *Mount
+}
+
+func (u mountUpload) Commit(policy string) (string, error) {
+	name, err := u.Upload.Commit(policy)
+	if err != nil {
+		return name, err
+	}
+	if rel, err := filepath.Rel(u.m.Dir, name); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		name = filepath.Join(u.m.root, rel)
+	}
+	return name, nil
+}
+
+func (d *mountDriver) Mkdir(name string, perm os.FileMode) error {
+	fs, p, err := d.writable("mkdir", name)
+	if err != nil {
+		return err
+	}
+	return fs.Mkdir(p, perm)
+}
+
+func (d *mountDriver) Chmod(name string, mode os.FileMode) error {
+	fs, p, err := d.writable("chmod", name)
+	if err != nil {
+		return err
+	}
+	return fs.Chmod(p, mode)
+}
+
+func (d *mountDriver) Chtimes(name string, atime, mtime time.Time) error {
+	fs, p, err := d.writable("chtimes", name)
+	if err != nil {
+		return err
+	}
+	return fs.Chtimes(p, atime, mtime)
+}
+
+func (d *mountDriver) Symlink(target, name string) error {
+	fs, p, err := d.writable("symlink", name)
+	if err != nil {
+		return err
+	}
+	return fs.Symlink(target, p)
+}
+
+func (d *mountDriver) Remove(name string) error {
+	if m, _ := d.find(name); (m != nil && name == m.root) || d.leadsToMount(name) {
+		return &os.PathError{Op: "remove", Path: name, Err: errMountPoint}
+	}
+	fs, p, err := d.writable("remove", name)
+	if err != nil {
+		return err
+	}
+	return fs.Remove(p)
+}
+
+// pair returns the driver of from and to and their paths there. Both must be
+// writable and in the same mount.
+func (d *mountDriver) pair(op, from, to string) (Driver, string, string, error) {
+	for _, name := range []string{from, to} {
+		if m, _ := d.find(name); (m != nil && name == m.root) || d.leadsToMount(name) {
+			return nil, "", "", &os.LinkError{Op: op, Old: from, New: to, Err: errMountPoint}
+		}
+	}
+	mf, _ := d.find(from)
+	mt, _ := d.find(to)
+	if mf != mt {
+		return nil, "", "", &os.LinkError{Op: op, Old: from, New: to, Err: errCrossMount}
+	}
+	fs, p, err := d.writable(op, from)
+	if err != nil {
+		return nil, "", "", err
+	}
+	_, q := d.find(to)
+	return fs, p, q, nil
+}
+
+func (d *mountDriver) Rename(from, to string) error {
+	fs, p, q, err := d.pair("rename", from, to)
+	if err != nil {
+		return err
+	}
+	return fs.Rename(p, q)
+}
+
+func (d *mountDriver) Link(from, to string) error {
+	fs, p, q, err := d.pair("link", from, to)
+	if err != nil {
+		return err
+	}
+	return fs.Link(p, q)
+}
+
+// mountsOf returns the mount driver behind fs, nil if there is none.
+func mountsOf(fs Driver) *mountDriver {
+	if a, ok := fs.(*anonDriver); ok {
+		fs = a.Driver
+	}
+	d, _ := fs.(*mountDriver)
+	return d
+}
+
+// hostPath returns where the namespace path name is kept in the host filesystem.
+func hostPath(fs Driver, name string) string {
+	if d := mountsOf(fs); d != nil {
+		_, p := d.find(name)
+		return p
+	}
+	return name
+}
+
+// metaRoot returns the directory whose metaDir keeps the versions and the
+// trash of name: the mount point of its mount, or Root.
+func metaRoot(fs Driver, name string) string {
+	if d := mountsOf(fs); d != nil {
+		if m, _ := d.find(name); m != nil {
+			return m.root
+		}
+	}
+	return Root
+}
+
+// metaRoots returns Root and the mount points of fs.
+func metaRoots(fs Driver) []string {
+	roots := []string{Root}
+	if d := mountsOf(fs); d != nil {
+		for _, m := range d.mounts {
+			roots = append(roots, m.root)
+		}
+	}
+	return roots
+}
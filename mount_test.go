@@ -0,0 +1,109 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMounts(t *testing.T) {
+	oldRoot := Root
+	defer func() { Root = oldRoot }()
+	Root = testRoot(t, "readme.txt")
+	incoming, releases := testRoot(t), testRoot(t, "v1.0/app.tgz")
+	mounts := filepath.Join(testRoot(t), "mounts.json")
+	assert.NoError(t, ioutil.WriteFile(mounts, []byte(`[
+		{"path": "/incoming", "dir": "`+incoming+`"},
+		{"path": "/pub/releases", "dir": "`+releases+`", "read_only": true}
+	]`), 0644))
+	d, err := loadMounts(mounts, localDriver{})
+	assert.NoError(t, err)
+	s := &Session{Root: Root, Dir: ".", FS: d}
+	names := func(dir string) []string {
+		entries, err := readDir(d, filepath.Join(Root, dir))
+		assert.NoError(t, err)
+		var list []string
+		for _, v := range entries {
+			list = append(list, v.Name())
+		}
+		return list
+	}
+
+	// the root shows the mount points and the directories leading to them
+	assert.Equal(t, []string{"incoming", "pub", "readme.txt"}, names("."))
+	assert.Equal(t, []string{"releases"}, names("pub"))
+	assert.Equal(t, []string{"v1.0"}, names("pub/releases"))
+	assert.NoError(t, cd(s, []string{"cd", "/pub/releases/v1.0"}))
+	assert.Equal(t, filepath.Join("pub", "releases", "v1.0"), s.Dir)
+	assert.Contains(t, string(ls(s, []string{"ls"})), "app.tgz")
+	s.Dir = "."
+
+	// writes go to the host directory of the mount
+	f, err := d.Create(filepath.Join(Root, "incoming", "a.csv"))
+	assert.NoError(t, err)
+	f.Write([]byte("a,b"))
+	_, err = f.Commit(OverwriteReplace)
+	assert.NoError(t, err)
+	data, err := ioutil.ReadFile(filepath.Join(incoming, "a.csv"))
+	assert.NoError(t, err)
+	assert.Equal(t, "a,b", string(data))
+	assert.NoError(t, cp(s, []string{"cp", "/incoming/b.csv", "/incoming/a.csv"}))
+	assert.FileExists(t, filepath.Join(incoming, "b.csv"))
+
+	// committed names are in the namespace, also when the policy renames
+	f, err = d.Create(filepath.Join(Root, "incoming", "a.csv"))
+	assert.NoError(t, err)
+	f.Write([]byte("c,d"))
+	final, err := f.Commit(OverwriteRename)
+	assert.NoError(t, err)
+	assert.Equal(t, filepath.Join(Root, "incoming", "a.1.csv"), final)
+	assert.Equal(t, "/incoming/a.1.csv", s.clientPath(final))
+	assert.FileExists(t, filepath.Join(incoming, "a.1.csv"))
+	assert.NoError(t, os.Remove(filepath.Join(incoming, "a.1.csv")))
+
+	// read-only mounts refuse every change
+	_, err = d.Create(filepath.Join(Root, "pub/releases/new.tgz"))
+	assert.Error(t, err)
+	assert.Error(t, d.Mkdir(filepath.Join(Root, "pub/releases/v2.0"), 0755))
+	err = rm(s, []string{"rm", "/pub/releases/v1.0/app.tgz"})
+	assert.True(t, err != nil && strings.Contains(err.Error(), "read-only"), "%v", err)
+	assert.FileExists(t, filepath.Join(releases, "v1.0/app.tgz"))
+
+	// nothing moves between mounts, mount points stay where they are
+	assert.Error(t, d.Rename(filepath.Join(Root, "incoming/a.csv"), filepath.Join(Root, "a.csv")))
+	assert.Error(t, d.Remove(filepath.Join(Root, "incoming")))
+	assert.Error(t, d.Remove(filepath.Join(Root, "pub")))
+
+	// deleted files go to the trash of their mount
+	assert.NoError(t, rm(s, []string{"rm", "/incoming/a.csv", "readme.txt"}))
+	_, err = os.Stat(filepath.Join(incoming, metaDir, "trash"))
+	assert.NoError(t, err)
+	list := string(trash(s, []string{"trash", "ls"}))
+	assert.Contains(t, list, " /incoming/a.csv\n")
+	assert.Contains(t, list, " /readme.txt\n")
+	assert.Empty(t, string(trash(s, []string{"trash", "empty"})))
+	assert.Equal(t, []string{"b.csv"}, names("incoming"), "the trash of a mount is hidden")
+}
+
+func TestLoadMountsErrors(t *testing.T) {
+	oldRoot := Root
+	defer func() { Root = oldRoot }()
+	Root = testRoot(t)
+	dir := testRoot(t)
+	for _, mounts := range []string{
+		`[{"path": "/", "dir": "` + dir + `"}]`,
+		`[{"path": "/a", "dir": "` + dir + `/missing"}]`,
+		`[{"path": "/a", "dir": "` + dir + `"}, {"path": "a/", "dir": "` + dir + `"}]`,
+		`[{"path": "/a/.goftp", "dir": "` + dir + `"}]`,
+		`[{"path": "/a", "dir": "` + dir + `", "storage": "dedup"}]`,
+	} {
+		path := filepath.Join(testRoot(t), "mounts.json")
+		assert.NoError(t, ioutil.WriteFile(path, []byte(mounts), 0644))
+		_, err := loadMounts(path, localDriver{})
+		assert.Error(t, err, mounts)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// notifyTimeout bounds one webhook request or command run.
+const notifyTimeout = 10 * time.Second
+
+// notifyRule sends the events matching Events and Path to URL and Command.
+// An empty Events matches every event, an empty Path every path, including
+// the events which have no path.
+type notifyRule struct {
+	Events  []string `json:"events"`
+	Path    string   `json:"path"`
+	URL     string   `json:"url"`
+	Command []string `json:"command"`
+}
+
+// notification is the JSON body posted to webhooks.
+type notification struct {
+	Event   string    `json:"event"`
+	Session uint64    `json:"session"`
+	User    string    `json:"user"`
+	Addr    string    `json:"addr"`
+	Path    string    `json:"path,omitempty"`
+	To      string    `json:"to,omitempty"`
+	Size    int64     `json:"size,omitempty"`
+	Modify  string    `json:"modify,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+	file    string
+}
+
+// notifier implements Hooks by sending the events to webhooks and external
+// commands. It is registered as an asynchronous hook.
+type notifier struct {
+	NopHooks
+	rules   []notifyRule
+	retries int
+	backoff time.Duration
+	client  *http.Client
+}
+
+// loadNotifier reads the notification rules from the JSON file path.
+func loadNotifier(path string) (*notifier, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []notifyRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	for i, r := range rules {
+		if r.URL == "" && len(r.Command) == 0 {
+			return nil, fmt.Errorf("%s: rule %d has neither url nor command", path, i)
+		}
+		for _, e := range r.Events {
+			switch e {
+			case EventLogin, EventUpload, EventUploadFailed, EventDownload, EventDelete, EventRename, EventDisconnect:
+			default:
+				return nil, fmt.Errorf("%s: rule %d: unknown event %s", path, i, e)
+			}
+		}
+		if _, err := filepath.Match(r.Path, ""); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: bad pattern %s", path, i, r.Path)
+		}
+	}
+	return &notifier{
+		rules:   rules,
+		retries: option.NotifyRetries,
+		backoff: option.NotifyBackoff,
+		client:  &http.Client{Timeout: notifyTimeout},
+	}, nil
+}
+
+func (n *notifier) OnLogin(s *Session) error {
+	return n.send(s, EventLogin, FileEvent{}, nil)
+}
+
+func (n *notifier) OnUpload(s *Session, f FileEvent) error {
+	return n.send(s, EventUpload, f, nil)
+}
+
+func (n *notifier) OnUploadFailed(s *Session, f FileEvent, err error) {
+	if err := n.send(s, EventUploadFailed, f, err); err != nil {
+		fmt.Println("hook error!", err)
+	}
+}
+
+func (n *notifier) OnDownload(s *Session, f FileEvent) error {
+	return n.send(s, EventDownload, f, nil)
+}
+
+func (n *notifier) OnDelete(s *Session, f FileEvent) error {
+	return n.send(s, EventDelete, f, nil)
+}
+
+func (n *notifier) OnRename(s *Session, f FileEvent) error {
+	return n.send(s, EventRename, f, nil)
+}
+
+func (n *notifier) OnDisconnect(s *Session) {
+	if err := n.send(s, EventDisconnect, FileEvent{}, nil); err != nil {
+		fmt.Println("hook error!", err)
+	}
+}
+
+// send delivers event to every matching rule.
+func (n *notifier) send(s *Session, event string, f FileEvent, failure error) error {
+	msg := notification{
+		Event:   event,
+		Session: s.ID,
+		User:    s.User,
+		Path:    f.Path,
+		To:      f.To,
+		Size:    f.Size,
+		Time:    time.Now(),
+	}
+	if s.Conn != nil {
+		msg.Addr = s.Conn.RemoteAddr().String()
+	}
+	if !f.ModTime.IsZero() {
+		msg.Modify = f.ModTime.UTC().Format(time.RFC3339)
+	}
+	if failure != nil {
+		msg.Error = failure.Error()
+	}
+	if f.Path != "" {
+		msg.file = hostPath(s.FS, filepath.Join(s.Root, filepath.FromSlash(f.Path)))
+	}
+	var errs []byte
+	for _, r := range n.rules {
+		if !r.matches(event, f.Path) {
+			continue
+		}
+		if r.URL != "" {
+			if err := n.retry(func() error { return n.post(r.URL, &msg) }); err != nil {
+				errs = append(errs, r.URL+": "+err.Error()+"\n"...)
+			}
+		}
+		if len(r.Command) > 0 {
+			if err := n.retry(func() error { return run(r.Command, &msg) }); err != nil {
+				errs = append(errs, r.Command[0]+": "+err.Error()+"\n"...)
+			}
+		}
+	}
+	if errs != nil {
+		return errors.New(string(errs))
+	}
+	return nil
+}
+
+// matches reports whether the rule selects event on the client path.
+func (r *notifyRule) matches(event, path string) bool {
+	if len(r.Events) > 0 {
+		found := false
+		for _, e := range r.Events {
+			found = found || e == event
+		}
+		if !found {
+			return false
+		}
+	}
+	if r.Path == "" {
+		return true
+	}
+	return path != "" && matchPath(r.Path, path)
+}
+
+// retry calls fn until it succeeds, at most 1+n.retries times, doubling the
+// pause between the attempts.
+func (n *notifier) retry(fn func() error) error {
+	pause := n.backoff
+	for i := 0; ; i++ {
+		err := fn()
+		if err == nil || i >= n.retries {
+			return err
+		}
+		time.Sleep(pause)
+		pause *= 2
+	}
+}
+
+// post sends msg as JSON to url. Any status but 2xx is an error.
+func (n *notifier) post(url string, msg *notification) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return errors.New(resp.Status)
+	}
+	return nil
+}
+
+// run runs command with the host path of the file as the last argument. The
+// details of the event are passed in GOFTP_ variables.
+func run(command []string, msg *notification) error {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+	args := command[1:]
+	if msg.file != "" {
+		args = append(args[:len(args):len(args)], msg.file)
+	}
+	cmd := exec.CommandContext(ctx, command[0], args...)
+	cmd.Env = append(os.Environ(),
+		"GOFTP_EVENT="+msg.Event,
+		"GOFTP_SESSION="+strconv.FormatUint(msg.Session, 10),
+		"GOFTP_USER="+msg.User,
+		"GOFTP_ADDR="+msg.Addr,
+		"GOFTP_PATH="+msg.Path,
+		"GOFTP_TO="+msg.To,
+		"GOFTP_ERROR="+msg.Error,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil && len(out) > 0 {
+		return fmt.Errorf("%v: %s", err, bytes.TrimSpace(out))
+	}
+	return err
+}
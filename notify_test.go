@@ -0,0 +1,86 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testNotifier writes rules to a file and loads them.
+func testNotifier(t *testing.T, rules []notifyRule) *notifier {
+	data, err := json.Marshal(rules)
+	assert.NoError(t, err)
+	path := filepath.Join(testRoot(t), "notify.json")
+	assert.NoError(t, ioutil.WriteFile(path, data, 0644))
+	n, err := loadNotifier(path)
+	assert.NoError(t, err)
+	n.backoff = time.Millisecond
+	return n
+}
+
+func TestNotifyWebhook(t *testing.T) {
+	attempts := 0
+	received := make(chan notification, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var msg notification
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&msg))
+		received <- msg
+	}))
+	defer srv.Close()
+
+	n := testNotifier(t, []notifyRule{{Events: []string{EventUpload}, Path: "/in/**/*.csv", URL: srv.URL}})
+	s := &Session{ID: 7, User: "partner", Root: "/srv"}
+	assert.NoError(t, n.OnUpload(s, FileEvent{Path: "/in/2026/a.csv", Size: 12}))
+	assert.NoError(t, n.OnUpload(s, FileEvent{Path: "/in/a.txt"}))
+	assert.NoError(t, n.OnDownload(s, FileEvent{Path: "/in/a.csv"}))
+	assert.Equal(t, 2, attempts)
+	msg := <-received
+	assert.Equal(t, EventUpload, msg.Event)
+	assert.Equal(t, uint64(7), msg.Session)
+	assert.Equal(t, "partner", msg.User)
+	assert.Equal(t, "/in/2026/a.csv", msg.Path)
+	assert.Equal(t, int64(12), msg.Size)
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	n.retries = 1
+	assert.EqualError(t, n.OnUpload(s, FileEvent{Path: "/in/b.csv"}), srv.URL+": 500 Internal Server Error\n")
+}
+
+func TestNotifyCommand(t *testing.T) {
+	root := testRoot(t)
+	out := filepath.Join(root, "out")
+	n := testNotifier(t, []notifyRule{{Command: []string{"sh", "-c", `echo "$GOFTP_EVENT $1" > ` + out, "sh"}}})
+	s := &Session{Root: root}
+	assert.NoError(t, n.OnUpload(s, FileEvent{Path: "/in/a.csv"}))
+	data, err := ioutil.ReadFile(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "upload "+filepath.Join(root, "in", "a.csv")+"\n", string(data))
+
+	n = testNotifier(t, []notifyRule{{Command: []string{"false"}}})
+	n.retries = 0
+	assert.EqualError(t, n.OnUpload(s, FileEvent{Path: "/in/a.csv"}), "false: exit status 1\n")
+}
+
+func TestLoadNotifier(t *testing.T) {
+	root := testRoot(t)
+	path := filepath.Join(root, "notify.json")
+	ioutil.WriteFile(path, []byte(`[{"events":["upload"]}]`), 0644)
+	_, err := loadNotifier(path)
+	assert.EqualError(t, err, path+": rule 0 has neither url nor command")
+	ioutil.WriteFile(path, []byte(`[{"events":["uploaded"],"url":"http://localhost/"}]`), 0644)
+	_, err = loadNotifier(path)
+	assert.EqualError(t, err, path+": rule 0: unknown event uploaded")
+}
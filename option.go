@@ -0,0 +1,79 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Option holds the server settings. Every field can be set by a flag or an ENV
+// variable, see flag.FlagSet.AddOption.
+type Option struct {
+	Compression      string        `desc:"default transfer compression: none, deflate, gzip or zstd"`
+	CompressionLevel int           `desc:"compression level, -1 means the default of the codec"`
+	CompressSkip     string        `desc:"comma separated extensions which are never compressed"`
+	Verify           bool          `desc:"send a sha256 checksum after every transfer and check it"`
+	Overwrite        string        `desc:"what ul does with an existing file: overwrite, fail or rename"`
+	MaxStreams       int           `desc:"maximum number of data connections of a parallel transfer"`
+	MaxLineLength    int           `desc:"maximum length of a command line in bytes"`
+	MaxGlobMatches   int           `desc:"maximum number of files a pattern may match"`
+	Notify           string        `desc:"JSON file with the webhook and command notification rules"`
+	NotifyRetries    int           `desc:"how often a failed notification is retried"`
+	NotifyBackoff    time.Duration `desc:"pause before the first retry of a notification, doubled after each retry"`
+	Users            string        `desc:"JSON file with the users, everybody may connect without it"`
+	AuditLog         string        `desc:"file the audit log is appended to"`
+	ACL              string        `desc:"JSON file with the networks clients may or may not connect from"`
+	Storage          string        `desc:"storage driver: local, or dedup to store identical files once"`
+	Mounts           string        `desc:"JSON file with the directories mounted into the namespace"`
+	BlobDir          string        `desc:"directory of the blobs of the dedup storage, outside of the root"`
+	LockPolicy       string        `desc:"what a transfer of a file in use does: reject, or wait up to lock-timeout"`
+	LockTimeout      time.Duration `desc:"how long a transfer waits for a file in use with the wait lock policy"`
+	Versions         int           `desc:"number of replaced versions kept of every file, 0 keeps them only for version-age"`
+	VersionAge       time.Duration `desc:"how long replaced versions are kept, 0 keeps them until versions are too many"`
+	TrashRetention   time.Duration `desc:"how long deleted files stay in the trash, 0 keeps them until trash empty"`
+	Anonymous        bool          `desc:"let anonymous and ftp log in with any password to the anon-root"`
+	AnonRoot         string        `desc:"read-only directory of anonymous sessions, relative to the root"`
+	AnonIncoming     string        `desc:"write-only directory of anonymous uploads, relative to the anon-root, empty allows none"`
+	SFTP             string        `desc:"address the SFTP server listens on, e.g. :2022, empty disables it"`
+	HostKey          string        `desc:"SSH host key file of the SFTP server outside of the root, created if missing"`
+	HTTP             string        `desc:"address the HTTP and WebDAV gateway listens on, e.g. :8080, empty disables it"`
+	Admin            string        `desc:"address the admin JSON API listens on, e.g. 127.0.0.1:8081, empty disables it"`
+	MaxLoginFailures int           `desc:"failed logins after which an IP is banned, 0 never bans"`
+	LoginDelay       time.Duration `desc:"pause after a failed login, doubled with every further failure"`
+	BanTime          time.Duration `desc:"how long an IP stays banned and failures are remembered"`
+}
+
+var option = &Option{
+	Compression:      "none",
+	CompressionLevel: -1,
+	Overwrite:        OverwriteReplace,
+	MaxStreams:       8,
+	MaxLineLength:    4096,
+	MaxGlobMatches:   1000,
+	NotifyRetries:    3,
+	NotifyBackoff:    time.Second,
+	AnonRoot:         "pub",
+	AnonIncoming:     "incoming",
+	LockPolicy:       LockReject,
+	LockTimeout:      30 * time.Second,
+	TrashRetention:   30 * 24 * time.Hour,
+	MaxLoginFailures: 5,
+	LoginDelay:       time.Second,
+	BanTime:          15 * time.Minute,
+	Storage:          StorageLocal,
+	CompressSkip:     ".gz,.tgz,.bz2,.xz,.zst,.zip,.7z,.rar,.jpg,.jpeg,.png,.gif,.webp,.mp3,.mp4,.mkv,.mov,.avi",
+}
+
+// skipCompression reports whether name has an extension listed in CompressSkip.
+func skipCompression(name string) bool {
+	ext := strings.ToLower(filepath.Ext(name))
+	if ext == "" {
+		return false
+	}
+	for _, v := range strings.Split(option.CompressSkip, ",") {
+		if strings.TrimSpace(strings.ToLower(v)) == ext {
+			return true
+		}
+	}
+	return false
+}
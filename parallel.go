@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/moshuipan/goftp/transfer"
+)
+
+// dataTimeout bounds how long a parallel transfer waits for its data connections.
+const dataTimeout = 30 * time.Second
+
+// listenData opens the listener for the data connections of a parallel transfer
+// and fills in Port and Token of h.
+func listenData(conn *net.TCPConn, h *transfer.Header) (*net.TCPListener, error) {
+	local := conn.LocalAddr().(*net.TCPAddr)
+	ln, err := net.ListenTCP("tcp", &net.TCPAddr{IP: local.IP})
+	if err != nil {
+		return nil, errors.New(err.Error() + "\n")
+	}
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		ln.Close()
+		return nil, errors.New(err.Error() + "\n")
+	}
+	h.Port = ln.Addr().(*net.TCPAddr).Port
+	h.Token = hex.EncodeToString(token)
+	return ln, nil
+}
+
+// acceptData accepts the h.Streams data connections announced in h.
+// Connections which do not start with the token of the transfer are dropped.
+func acceptData(ln *net.TCPListener, h *transfer.Header) ([]net.Conn, error) {
+	defer ln.Close()
+	deadline := time.Now().Add(dataTimeout)
+	ln.SetDeadline(deadline)
+	conns := make([]net.Conn, 0, h.Streams)
+	token := make([]byte, len(h.Token))
+	for len(conns) < h.Streams {
+		c, err := ln.Accept()
+		if err != nil {
+			closeAll(conns)
+			return nil, errors.New(err.Error() + "\n")
+		}
+		c.SetDeadline(deadline)
+		if _, err := io.ReadFull(c, token); err != nil || string(token) != h.Token {
+			c.Close()
+			continue
+		}
+		c.SetDeadline(time.Time{})
+		conns = append(conns, c)
+	}
+	return conns, nil
+}
+
+func closeAll(conns []net.Conn) {
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// streams returns the number of data connections used for a transfer when the
+// client asks for n, it is never more than MaxStreams.
+func streams(n int) int {
+	if n > option.MaxStreams {
+		n = option.MaxStreams
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// parallelDownload sends f in h.Streams ranges, each over its own data connection.
+func parallelDownload(conn *net.TCPConn, f io.ReaderAt, h *transfer.Header) error {
+	ln, err := listenData(conn, h)
+	if err != nil {
+		return refuse(conn, err)
+	}
+	if err := transfer.WriteHeader(conn, h); err != nil {
+		ln.Close()
+		return errors.New(err.Error() + "\n")
+	}
+	conns, err := acceptData(ln, h)
+	if err != nil {
+		return err
+	}
+	defer closeAll(conns)
+	ranges := transfer.Ranges(h.Size, h.Streams)
+	return eachConn(conns, func(i int, c net.Conn) error {
+		return transfer.SendRange(c, f, ranges[i][0], ranges[i][1], h, option.CompressionLevel)
+	})
+}
+
+// parallelUpload receives the ranges of an upload over h.Streams data connections into f.
+func parallelUpload(conn *net.TCPConn, f io.WriterAt, h *transfer.Header) error {
+	ln, err := listenData(conn, h)
+	if err != nil {
+		return refuse(conn, err)
+	}
+	if err := transfer.WriteHeader(conn, h); err != nil {
+		ln.Close()
+		return errors.New(err.Error() + "\n")
+	}
+	conns, err := acceptData(ln, h)
+	if err != nil {
+		return err
+	}
+	defer closeAll(conns)
+	return eachConn(conns, func(i int, c net.Conn) error {
+		return transfer.ReceiveRange(f, c, h)
+	})
+}
+
+// eachConn runs fn for every data connection concurrently and returns the first error.
+func eachConn(conns []net.Conn, fn func(i int, c net.Conn) error) error {
+	var (
+		wg       sync.WaitGroup
+		once     sync.Once
+		firstErr error
+	)
+	for i, c := range conns {
+		wg.Add(1)
+		go func(i int, c net.Conn) {
+			defer wg.Done()
+			if err := fn(i, c); err != nil {
+				once.Do(func() { firstErr = errors.New(err.Error() + "\n") })
+				// let the other streams fail fast.
+				closeAll(conns)
+			}
+		}(i, c)
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/moshuipan/goftp/transfer"
+	"github.com/stretchr/testify/assert"
+)
+
+// dialData opens the data connections announced in h the way a client does.
+func dialData(t *testing.T, h *transfer.Header) []net.Conn {
+	conns := make([]net.Conn, 0, h.Streams)
+	for i := 0; i < h.Streams; i++ {
+		c, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: h.Port})
+		assert.NoError(t, err)
+		_, err = io.WriteString(c, h.Token)
+		assert.NoError(t, err)
+		conns = append(conns, c)
+	}
+	return conns
+}
+
+// bufferAt is an io.WriterAt in memory.
+type bufferAt struct {
+	sync.Mutex
+	b []byte
+}
+
+func (w *bufferAt) WriteAt(p []byte, off int64) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	if end := int(off) + len(p); end > len(w.b) {
+		w.b = append(w.b, make([]byte, end-len(w.b))...)
+	}
+	return copy(w.b[off:], p), nil
+}
+
+func TestParallel(t *testing.T) {
+	saved, oldRoot, oldStorage := *option, Root, storage
+	defer func() { *option, Root, storage = saved, oldRoot, oldStorage }()
+	option.Overwrite, option.MaxStreams = OverwriteReplace, 3
+	Root = testRoot(t, "in/x")
+	storage = localDriver{}
+	data := []byte(strings.Repeat("0123456789abcdef", 4096) + "tail")
+
+	server, client := testConn(t)
+	s := newSession(server)
+	s.Verify = true
+
+	// upload in three ranges, the fourth stream asked for is capped.
+	done := make(chan error, 1)
+	go func() { done <- upload(s, []string{"ul", "-n", "4", "in", "big.bin"}) }()
+	h, err := transfer.ReadHeader(client)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, h.Streams)
+	assert.Equal(t, verifyHash, h.Digest)
+	conns := dialData(t, h)
+	ranges := transfer.Ranges(int64(len(data)), h.Streams)
+	assert.NoError(t, eachConn(conns, func(i int, c net.Conn) error {
+		return transfer.SendRange(c, bytes.NewReader(data), ranges[i][0], ranges[i][1], h, 0)
+	}))
+	closeAll(conns)
+	assert.NoError(t, <-done)
+	assert.Equal(t, string(data), readFile(t, filepath.Join(Root, "in/big.bin")))
+
+	// download it again in two ranges.
+	go func() { done <- download(s, []string{"dl", "-n", "2", ".", "in/big.bin"}) }()
+	h, err = transfer.ReadHeader(client)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, h.Streams)
+	assert.Equal(t, int64(len(data)), h.Size)
+	conns = dialData(t, h)
+	var got bufferAt
+	assert.NoError(t, eachConn(conns, func(i int, c net.Conn) error {
+		return transfer.ReceiveRange(&got, c, h)
+	}))
+	closeAll(conns)
+	assert.NoError(t, <-done)
+	assert.Equal(t, data, got.b)
+
+	// a range whose digest does not match fails the upload.
+	go func() { done <- upload(s, []string{"ul", "-n", "2", "in", "big.bin"}) }()
+	h, err = transfer.ReadHeader(client)
+	assert.NoError(t, err)
+	conns = dialData(t, h)
+	ranges = transfer.Ranges(int64(len(data)), h.Streams)
+	eachConn(conns, func(i int, c net.Conn) error {
+		if i == 0 {
+			return transfer.SendRange(c, bytes.NewReader(data), ranges[i][0], ranges[i][1], h, 0)
+		}
+		// send the range unverified and append the digest of other content.
+		sent := *h
+		sent.Digest = ""
+		if err := transfer.SendRange(c, strings.NewReader("changed"), 0, 7, &sent, 0); err != nil {
+			return err
+		}
+		sum := sha256.Sum256([]byte("something else"))
+		return transfer.WriteDigest(c, sum[:])
+	})
+	closeAll(conns)
+	assert.Error(t, <-done)
+	assert.Equal(t, string(data), readFile(t, filepath.Join(Root, "in/big.bin")))
+
+	tmp, _ := filepath.Glob(filepath.Join(Root, "in", ".*.tmp"))
+	assert.Empty(t, tmp)
+}
+
+func TestStreams(t *testing.T) {
+	saved := *option
+	defer func() { *option = saved }()
+	option.MaxStreams = 4
+	for n, want := range map[int]int{-1: 1, 0: 1, 1: 1, 3: 3, 4: 4, 100: 4} {
+		assert.Equal(t, want, streams(n), "%d streams", n)
+	}
+}
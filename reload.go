@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reload reads the users file and the access lists again for s, nil on
+// SIGHUP. A file which cannot be read leaves its old settings in place. The
+// notify rules and the mounts are only read at startup.
+func reload(s *Session) error {
+	var errs Buffer
+	if option.Users != "" {
+		if err := loadUsers(option.Users); err != nil {
+			errs.Write([]byte(err.Error() + "\n"))
+		}
+	}
+	if option.ACL != "" {
+		if err := loadACL(option.ACL); err != nil {
+			errs.Write([]byte(err.Error() + "\n"))
+		}
+	}
+	audit(s, "reload")
+	if errs != nil {
+		return errors.New(string(errs))
+	}
+	return nil
+}
+
+// reloadOnSignal reloads the settings whenever the server gets SIGHUP.
+func reloadOnSignal() {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGHUP)
+	for range c {
+		if err := reload(nil); err != nil {
+			fmt.Print(err)
+		}
+	}
+}
+
+// reloadConfig lets an admin reload the settings.
+func reloadConfig(s *Session, args []string) error {
+	//reload
+	if !s.isAdmin() {
+		return errors.New("permission denied\n")
+	}
+	return reload(s)
+}
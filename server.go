@@ -3,21 +3,52 @@ package main
 import (
 	"fmt"
 	"io"
-	"io/ioutil"
 	"net"
 	// "time"
 	"errors"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/moshuipan/goftp/flag"
+	"github.com/moshuipan/goftp/transfer"
 )
 
 const (
-	CD = "cd"
-	LS = "ls"
-	CP = "cp"
-	UL = "ul"
-	DL = "dl"
+	CD     = "cd"
+	LS     = "ls"
+	CP     = "cp"
+	UL     = "ul"
+	DL     = "dl"
+	SYNC   = "sync"
+	MODE   = "mode"
+	VERIFY = "verify"
+
+	HASH    = "hash"
+	XMD5    = "xmd5"
+	XSHA1   = "xsha1"
+	XSHA256 = "xsha256"
+
+	MLSD = "mlsd"
+	MLST = "mlst"
+
+	TYPE = "type"
+	SIZE = "size"
+
+	USER   = "user"
+	PASS   = "pass"
+	BANS   = "bans"
+	UNBAN  = "unban"
+	RELOAD = "reload"
+
+	VERSIONS = "versions"
+	RESTORE  = "restore"
+	RM       = "rm"
+	TRASH    = "trash"
+	LOCKS    = "locks"
 )
 
 var Root string
@@ -39,6 +70,87 @@ func init() {
 	}
 }
 func main() {
+	fs := flag.NewFlagSet(nil)
+	fs.AddOption("goftp", option)
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if !transfer.ValidCodec(option.Compression) {
+		fmt.Println("unknown codec", option.Compression)
+		os.Exit(1)
+	}
+	if !validOverwrite(option.Overwrite) {
+		fmt.Println("unknown overwrite policy", option.Overwrite)
+		os.Exit(1)
+	}
+	if option.LockPolicy != LockReject && option.LockPolicy != LockWait {
+		fmt.Println("unknown lock policy", option.LockPolicy)
+		os.Exit(1)
+	}
+	if option.Users != "" {
+		if err := loadUsers(option.Users); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	var err error
+	if storage, err = newStorage(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if option.ACL != "" {
+		if err := loadACL(option.ACL); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	go reloadOnSignal()
+	if option.TrashRetention > 0 {
+		go purgeTrashLoop()
+	}
+	if option.VersionAge > 0 {
+		go purgeVersionsLoop()
+	}
+	if option.AuditLog != "" {
+		if err := openAudit(option.AuditLog); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		RegisterHooks(auditor{}, true)
+	}
+	if option.Notify != "" {
+		n, err := loadNotifier(option.Notify)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		RegisterHooks(n, true)
+	}
+	if option.SFTP != "" {
+		l, key, err := listenSFTP(option.SFTP)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		go serveSFTP(l, key)
+	}
+	if option.HTTP != "" {
+		l, err := listenHTTP(option.HTTP)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		go serveHTTP(l)
+	}
+	if option.Admin != "" {
+		l, err := listenAdmin(option.Admin)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		go serveAdmin(l)
+	}
 	listenaddr := &net.TCPAddr{
 		IP:   net.ParseIP("127.0.0.1"),
 		Port: 9091,
@@ -54,49 +166,142 @@ func main() {
 			fmt.Println(err) // e.g., connection aborted
 			continue
 		}
-		go handleConn(*conn) // handle one connection at a time
+		if !accepted(conn.RemoteAddr()) {
+			audit(nil, "denied", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+		s := newSession(conn)
+		sessions.add(s)
+		go handleConn(s) // handle one connection at a time
 	}
 }
 
-func handleConn(conn net.TCPConn) {
+func handleConn(s *Session) {
+	conn := s.Conn
 	defer conn.Close()
-	b := make([]byte, 512)
+	defer sessions.remove(s)
+	if until, ok := guard.banned(remoteIP(s)); ok {
+		conn.Write([]byte("banned until " + until.Format(time.RFC3339) + "\n"))
+		return
+	}
+	// with users the session starts at pass.
+	if !authRequired() {
+		if err := check(func(h Hooks) error { return h.OnLogin(s) }); err != nil {
+			conn.Write([]byte(err.Error()))
+			return
+		}
+		notify(func(h Hooks) error { return h.OnLogin(s) })
+	}
+	defer disconnected(s)
 	var out Buffer
-	currdir := "."
 	for {
-		conn.Write([]byte(currdir + "#"))
-		n, err := conn.Read(b)
+		conn.Write([]byte(s.Dir + "#"))
+		line, err := transfer.ReadLine(s.reader, option.MaxLineLength)
+		if err == transfer.ErrLineTooLong {
+			conn.Write([]byte(err.Error() + "\n"))
+			continue
+		}
 		if err != nil {
 			fmt.Println(err)
 			break
 		}
-		body := b[0:n]
-		fmt.Printf("%s\n", body)
-		s := fmt.Sprintf("%s", body)
-		ss := strings.Fields(s)
+		ss, literal, err := transfer.SplitQuoted(line)
+		if err != nil {
+			conn.Write([]byte(err.Error() + "\n"))
+			continue
+		}
+		s.literal = nil
+		for i, v := range literal {
+			if v {
+				if s.literal == nil {
+					s.literal = map[string]bool{}
+				}
+				s.literal[ss[i]] = true
+			}
+		}
+		if len(ss) == 0 {
+			continue
+		}
+		atomic.AddInt64(&s.stats.Commands, 1)
+		if s.User == "" && ss[0] != USER && ss[0] != PASS && authRequired() {
+			conn.Write([]byte("login with user and pass first\n"))
+			continue
+		}
 		switch ss[0] {
+		case USER:
+			if err := loginUser(s, ss); err != nil {
+				out.Write([]byte(err.Error()))
+			}
+		case PASS:
+			if err := loginPass(s, ss); err != nil {
+				out.Write([]byte(err.Error()))
+			}
+		case BANS:
+			out = bans(s, ss)
+		case UNBAN:
+			if err := unban(s, ss); err != nil {
+				out.Write([]byte(err.Error()))
+			}
+		case RELOAD:
+			if err := reloadConfig(s, ss); err != nil {
+				out.Write([]byte(err.Error()))
+			}
 		case LS:
-			out = ls(ss, currdir)
+			out = ls(s, ss)
 		case CD:
-			err := cd(ss, &currdir)
+			err := cd(s, ss)
 			if err != nil {
 				out.Write([]byte(err.Error()))
 			}
 		case CP:
-			err := cp(ss, currdir)
+			err := cp(s, ss)
 			if err != nil {
 				out.Write([]byte(err.Error()))
 			}
 		case UL:
-			err := upload(ss, conn, currdir)
+			err := upload(s, ss)
 			if err != nil {
 				out.Write([]byte(err.Error()))
 			}
 		case DL:
-			err := download(ss, conn, currdir)
+			err := download(s, ss)
 			if err != nil {
 				out.Write([]byte(err.Error()))
 			}
+		case SYNC:
+			err := syncFile(s, ss)
+			if err != nil {
+				out.Write([]byte(err.Error()))
+			}
+		case MODE:
+			out = mode(s, ss)
+		case HASH, XMD5, XSHA1, XSHA256:
+			out = checksum(s, ss)
+		case VERIFY:
+			out = verify(s, ss)
+		case MLSD:
+			out = mlsd(s, ss)
+		case MLST:
+			out = mlst(s, ss)
+		case TYPE:
+			out = setType(s, ss)
+		case SIZE:
+			out = size(s, ss)
+		case VERSIONS:
+			out = versions(s, ss)
+		case RESTORE:
+			if err := restore(s, ss); err != nil {
+				out.Write([]byte(err.Error()))
+			}
+		case RM:
+			if err := rm(s, ss); err != nil {
+				out.Write([]byte(err.Error()))
+			}
+		case TRASH:
+			out = trash(s, ss)
+		case LOCKS:
+			out = lockList(s, ss)
 		default:
 			out.Write([]byte("unknow commond!\n"))
 		}
@@ -104,189 +309,258 @@ func handleConn(conn net.TCPConn) {
 		out = nil
 	}
 }
-func download(args []string, conn net.TCPConn, currdir string) error {
-	//dl dst src
+func download(s *Session, args []string) error {
+	//dl [-z codec] [-n streams] dst src
+	args, opts, err := transferArgs(args, s.Codec)
+	if err != nil {
+		return refuse(s.Conn, err)
+	}
 	if len(args) != 3 {
-		return errors.New("ul dst src\n")
+		return refuse(s.Conn, errors.New("dl [-z codec] [-n streams] dst src\n"))
 	}
-	if err := checkurl(args[2], currdir); err != nil {
-		return err
+	if !transfer.HasGlob(args[2]) || s.literal[args[2]] {
+		return sendFile(s, args[2], opts)
 	}
-	f, err := os.Open(args[2])
+	names, err := s.expand(args[2])
 	if err != nil {
+		return refuse(s.Conn, err)
+	}
+	if err := transfer.WriteHeader(s.Conn, &transfer.Header{Files: names}); err != nil {
 		return errors.New(err.Error() + "\n")
 	}
-	defer f.Close()
-	buf := make([]byte, 1024)
-	var start int64 = 0
-	for {
-		n, err := f.ReadAt(buf, start)
-		if err != nil {
-			if err != io.EOF {
-				fmt.Println("read file error!", err)
-				return errors.New(err.Error() + "\n")
-			}
-		}
-		start += int64(n)
-		b := buf[0:n]
-		if len(b) == 1 {
-			b = append(b, 0xda)
+	var errs Buffer
+	for _, name := range names {
+		if err := sendFile(s, name, opts); err != nil {
+			errs.Write([]byte(err.Error()))
 		}
-		if len(b) == 0 {
-			fmt.Println("read all file!")
-			break
-		}
-		_, err = conn.Write(b)
-		if err != nil {
+	}
+	if errs != nil {
+		return errors.New(string(errs))
+	}
+	return nil
+}
+
+// sendFile sends the file name to the client, headed by its transfer header.
+func sendFile(s *Session, name string, opts transferOpts) error {
+	conn := s.Conn
+	path, err := s.resolve(name)
+	if err != nil {
+		return refuse(conn, err)
+	}
+	unlock, err := locks.lock(s, path, false)
+	if err != nil {
+		return refuse(conn, err)
+	}
+	defer unlock()
+	f, err := s.FS.Open(path)
+	if err != nil {
+		return refuse(conn, errors.New(err.Error()+"\n"))
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return refuse(conn, errors.New(err.Error()+"\n"))
+	}
+	if fi.IsDir() {
+		return refuse(conn, errors.New(name+" is a directory\n"))
+	}
+	event := s.fileEvent(path, fi)
+	if err := check(func(h Hooks) error { return h.OnDownload(s, event) }); err != nil {
+		return refuse(conn, err)
+	}
+	p := s.startTransfer(path, false, fi.Size())
+	defer s.endTransfer(p)
+	var pf File = progressFile{File: f, p: p}
+	h := &transfer.Header{Codec: codecFor(name, opts.codec)}
+	if s.Verify {
+		h.Digest = verifyHash
+	}
+	if opts.streams > 1 && s.Type == TypeBinary {
+		h.Streams, h.Size = opts.streams, fi.Size()
+		if err := parallelDownload(conn, pf, h); err != nil {
 			fmt.Println("send file error!", err)
-			return errors.New(err.Error() + "\n")
+			return err
 		}
+		s.sent(fi.Size())
+		notify(func(h Hooks) error { return h.OnDownload(s, event) })
+		fmt.Println("read all file!")
+		return nil
 	}
-	_, err = conn.Write([]byte{0xda})
-	if err != nil {
-		fmt.Println("send file error!")
+	if err := transfer.WriteHeader(conn, h); err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	var r io.Reader = pf
+	if s.Type == TypeASCII {
+		r = newCRLFReader(pf)
+	}
+	if err := transfer.Send(conn, r, h, option.CompressionLevel); err != nil {
+		fmt.Println("send file error!", err)
 		return errors.New(err.Error() + "\n")
 	}
+	s.sent(fi.Size())
+	notify(func(h Hooks) error { return h.OnDownload(s, event) })
+	fmt.Println("read all file!")
 	return nil
 }
-func upload(args []string, conn net.TCPConn, currdir string) error {
-	//ul dst src
+func upload(s *Session, args []string) (err error) {
+	//ul [-z codec] [-n streams] dst src
+	conn := s.Conn
+	args, opts, err := transferArgs(args, s.Codec)
+	if err != nil {
+		return refuse(conn, err)
+	}
 	if len(args) != 3 {
-		return errors.New("ul dst src\n")
+		return refuse(conn, errors.New("ul [-z codec] [-n streams] dst src\n"))
 	}
-	if err := checkurl(args[1], currdir); err != nil {
-		return err
+	dir, err := s.resolve(args[1])
+	if err != nil {
+		return refuse(conn, err)
 	}
 	_, filename := filepath.Split(args[2])
-	name := filepath.Join(args[1], filename)
-	f, err := os.Create(name)
+	name := filepath.Join(dir, filename)
+	defer func() {
+		if err != nil {
+			uploadFailed(s, s.fileEvent(name, nil), err)
+		}
+	}()
+	unlock, err := locks.lock(s, name, true)
 	if err != nil {
-		return errors.New(err.Error() + "\n")
+		return refuse(conn, err)
 	}
-	defer f.Close()
-	// conn.SetReadBuffer(1024 * 10000)
-	//end:0xda
-	buf := make([]byte, 1024)
-	for {
-		n, err := conn.Read(buf)
-		// _, err = io.Copy(f, conn)
-		if err != nil {
+	defer unlock()
+	if option.Overwrite == OverwriteFail {
+		if _, err := s.FS.Lstat(name); err == nil {
+			return refuse(conn, errors.New(args[1]+"/"+filename+" exists already\n"))
+		}
+	}
+	f, err := s.FS.Create(name)
+	if err != nil {
+		return refuse(conn, errors.New(err.Error()+"\n"))
+	}
+	defer f.Abort()
+	p := s.startTransfer(name, true, 0)
+	defer s.endTransfer(p)
+	w := progressUpload{Upload: f, p: p}
+	h := &transfer.Header{Codec: codecFor(filename, opts.codec)}
+	if s.Verify {
+		h.Digest = verifyHash
+	}
+	// ranges cannot be converted independently, ASCII uses a single stream.
+	if opts.streams > 1 && s.Type == TypeBinary {
+		h.Streams = opts.streams
+		if err := parallelUpload(conn, w, h); err != nil {
+			return err
+		}
+	} else {
+		if err := transfer.WriteHeader(conn, h); err != nil {
 			return errors.New(err.Error() + "\n")
 		}
-		b := buf[0:n]
-		if len(b) == 1 && b[0] == 0xda {
-			fmt.Println("upload end!")
-			break
-		} else {
-			if len(b) == 2 && b[1] == 0xda {
-				b = b[0:0]
+		if s.Type == TypeASCII {
+			lw := newLFWriter(w)
+			if err := transfer.Receive(lw, s.reader, h); err != nil {
+				return errors.New(err.Error() + "\n")
 			}
-			_, err = f.Write(b)
-			if err != nil {
+			if err := lw.Flush(); err != nil {
 				return errors.New(err.Error() + "\n")
 			}
+		} else if err := transfer.Receive(w, s.reader, h); err != nil {
+			return errors.New(err.Error() + "\n")
 		}
 	}
-	return nil
-}
-func cp(args []string, currdir string) error {
-	//cp dstdir+dstfilename src
-	if len(args) != 3 {
-		return errors.New("cp dstdir+dstfilename src\n")
-	}
-	if err := checkurl(args[2], currdir); err != nil {
-		return err
-	}
-	dir, _ := filepath.Split(args[1])
-	if err := checkurl(dir, currdir); err != nil {
-		return err
-	}
-	src, err := os.Open(args[2])
+	fi, err := f.Stat()
 	if err != nil {
 		return errors.New(err.Error() + "\n")
 	}
-	dst, err := os.Create(args[1])
-	if err != nil {
-		return errors.New(err.Error() + "\n")
+	if err := check(func(h Hooks) error { return h.OnUpload(s, s.fileEvent(name, fi)) }); err != nil {
+		return err
 	}
-	_, err = io.Copy(dst, src)
+	final, err := s.commit(f, name, option.Overwrite)
 	if err != nil {
-		return errors.New(err.Error() + "\n")
-	}
-	return nil
-}
-func cd(args []string, currdir *string) error {
-	//cd ..判断cd后的目录权限
-	if err := checkurl(args[1], *currdir); err != nil {
 		return err
 	}
-	path := filepath.Join(*currdir, args[1])
-	*currdir = path
+	s.received(fi.Size())
+	notify(func(h Hooks) error { return h.OnUpload(s, s.fileEvent(final, fi)) })
+	fmt.Println("upload end!")
 	return nil
 }
-func ls(args []string, currdir string) (out Buffer) {
-	//three args
-	//ls [-l]  [dir]
-	var f []os.FileInfo
-	var err error
-	if len(args) > 1 {
-		if len(args) == 3 {
-			if err := checkurl(args[2], currdir); err != nil {
-				out.Write([]byte(err.Error()))
-				return
-			}
-			f, err = ioutil.ReadDir(args[2])
-			if err != nil {
-				out.Write([]byte("read dir error!\n"))
-				return
-			}
-		} else if args[1] == "-l" {
-			f, err = ioutil.ReadDir(currdir)
-			if err != nil {
-				out.Write([]byte("read dir error!\n"))
-				return
-			}
-		} else {
-			if err := checkurl(args[1], currdir); err != nil {
-				out.Write([]byte(err.Error()))
-				return
+
+// transferOpts are the per-transfer options of ul and dl.
+type transferOpts struct {
+	codec   string
+	streams int
+}
+
+// transferArgs strips the per-transfer options "-z codec" and "-n streams" from args.
+// codec is the compression of the session, used unless -z is given.
+func transferArgs(args []string, codec string) ([]string, transferOpts, error) {
+	opts := transferOpts{codec: codec, streams: 1}
+	rest := []string{args[0]}
+	for i := 1; i < len(args); i++ {
+		if i+1 >= len(args) {
+			rest = append(rest, args[i])
+			continue
+		}
+		switch args[i] {
+		case "-z":
+			if !transfer.ValidCodec(args[i+1]) {
+				return nil, opts, errors.New("unknown codec " + args[i+1] + "\n")
 			}
-			f, err = ioutil.ReadDir(args[1])
-			if err != nil {
-				out.Write([]byte("read dir error!\n"))
-				return
+			opts.codec = args[i+1]
+			i++
+		case "-n":
+			n, err := strconv.Atoi(args[i+1])
+			if err != nil || n < 1 {
+				return nil, opts, errors.New("bad stream count " + args[i+1] + "\n")
 			}
+			opts.streams = streams(n)
+			i++
+		default:
+			rest = append(rest, args[i])
 		}
-	} else {
-		f, err = ioutil.ReadDir(currdir)
-		if err != nil {
-			out.Write([]byte("read dir error!\n"))
-			return
-		}
-	}
-	if len(args) >= 2 && args[1] == "-l" {
-		for _, v := range f {
-			out.Write([]byte(fmt.Sprint(v.Mode()) + "\t" + fmt.Sprint(v.Size()) + "\t" + v.Name() + "\n"))
-		}
-	} else {
-		for _, v := range f {
-			// out.Write([]byte(fmt.Sprint(v.Mode()) + "\t" + fmt.Sprint(v.Size()) + "\t" + v.Name() + "\n"))
-			out.Write([]byte(v.Name() + "\t"))
-		}
-		out.Write([]byte("\n"))
 	}
-	return
+	return rest, opts, nil
+}
+
+// refuse tells the client that a transfer will not take place and returns err.
+func refuse(conn io.Writer, err error) error {
+	transfer.WriteHeader(conn, &transfer.Header{Error: strings.TrimSpace(err.Error())})
+	return err
 }
-func checkurl(url string, currdir string) error {
-	path := filepath.Join(currdir, url)
-	p, err := filepath.Abs(path)
+func cd(s *Session, args []string) error {
+	//cd ..判断cd后的目录权限
+	if len(args) != 2 {
+		return errors.New("cd dir\n")
+	}
+	path, err := s.resolve(args[1])
 	if err != nil {
-		return errors.New(err.Error() + "\n")
+		return err
 	}
-	if strings.Contains(p, Root) {
-		return nil
+	if fi, err := s.FS.Stat(path); err != nil || !fi.IsDir() {
+		return errors.New(args[1] + " is not a directory\n")
+	}
+	s.mu.Lock()
+	s.Dir, _ = filepath.Rel(s.Root, path)
+	s.mu.Unlock()
+	return nil
+}
+
+// resolve returns the path of url on the host. url is relative to the working
+// directory or, when it starts with a slash, to the root of the session.
+// It fails for paths leading out of the root.
+func (s *Session) resolve(url string) (string, error) {
+	dir := s.Dir
+	if filepath.IsAbs(url) {
+		dir = "."
+	}
+	p := filepath.Join(s.Root, dir, url)
+	rel, err := filepath.Rel(s.Root, p)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errors.New("路径权限不够!\n")
+	}
+	if isMeta(p) {
+		return "", errors.New("路径权限不够!\n")
 	}
-	return errors.New("路径权限不够!\n")
+	return p, nil
 }
 
 /*func handleConn(c net.Conn) {
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session holds the state of one client connection. It is passed to every
+// command, and embedders can inspect it to see what a client is doing.
+type Session struct {
+	// ID identifies the session for the lifetime of the server.
+	ID uint64
+	// Protocol is how the client connects: ftp, sftp or http.
+	Protocol string
+	// Conn is the control connection.
+	Conn *net.TCPConn
+	// User is the authenticated user, empty before login.
+	User string
+	// Root is the directory the session is confined to.
+	Root string
+	// Dir is the working directory, relative to Root.
+	Dir string
+	// FS stores the files of the session.
+	FS Driver
+	// Type is the transfer type, "I" for binary.
+	Type string
+	// Codec is the compression of transfers, see mode.
+	Codec string
+	// Verify tells whether transfers carry a checksum trailer.
+	Verify bool
+	// RestartOffset is where the next transfer starts, for a restart
+	// command; 0 starts at the beginning.
+	RestartOffset int64
+	// RenameFrom is the path remembered by the first half of a two-step rename.
+	RenameFrom string
+	// TLS is the state of the control connection, nil while it is not encrypted.
+	TLS *tls.ConnectionState
+	// Started is when the client connected.
+	Started time.Time
+
+	reader *bufio.Reader
+	stats  SessionStats
+	// literal holds the arguments of the current command which were quoted,
+	// expand takes them as names.
+	literal   map[string]bool
+	account   *User
+	loginName string
+
+	// mu guards the fields Info reads from other goroutines. The session
+	// changes User, Root and Dir under mu, and reads them freely.
+	mu       sync.Mutex
+	transfer *progress
+}
+
+// SessionStats are the counters of a session.
+type SessionStats struct {
+	Commands int64 `json:"commands"`
+	FilesIn  int64 `json:"files_in"`
+	FilesOut int64 `json:"files_out"`
+	BytesIn  int64 `json:"bytes_in"`
+	BytesOut int64 `json:"bytes_out"`
+}
+
+var lastSessionID uint64
+
+// newSession creates the session of a new control connection.
+func newSession(conn *net.TCPConn) *Session {
+	return &Session{
+		ID:       atomic.AddUint64(&lastSessionID, 1),
+		Protocol: "ftp",
+		Conn:     conn,
+		Root:     Root,
+		Dir:      ".",
+		FS:       storage,
+		Type:     "I",
+		Codec:    option.Compression,
+		Verify:   option.Verify,
+		Started:  time.Now(),
+		reader:   bufio.NewReader(conn),
+	}
+}
+
+// isAdmin reports whether the user of s may manage the server.
+func (s *Session) isAdmin() bool {
+	return s.account != nil && s.account.Admin
+}
+
+// Stats returns a copy of the counters of s. It is safe to call it while the
+// session is running.
+func (s *Session) Stats() SessionStats {
+	return SessionStats{
+		Commands: atomic.LoadInt64(&s.stats.Commands),
+		FilesIn:  atomic.LoadInt64(&s.stats.FilesIn),
+		FilesOut: atomic.LoadInt64(&s.stats.FilesOut),
+		BytesIn:  atomic.LoadInt64(&s.stats.BytesIn),
+		BytesOut: atomic.LoadInt64(&s.stats.BytesOut),
+	}
+}
+
+// received counts a file of size bytes received from the client.
+func (s *Session) received(size int64) {
+	atomic.AddInt64(&s.stats.FilesIn, 1)
+	atomic.AddInt64(&s.stats.BytesIn, size)
+}
+
+// sent counts a file of size bytes sent to the client.
+func (s *Session) sent(size int64) {
+	atomic.AddInt64(&s.stats.FilesOut, 1)
+	atomic.AddInt64(&s.stats.BytesOut, size)
+}
+
+// SessionInfo describes what a session is doing.
+type SessionInfo struct {
+	ID       uint64        `json:"id"`
+	Protocol string        `json:"protocol"`
+	User     string        `json:"user"`
+	Addr     string        `json:"addr"`
+	Dir      string        `json:"dir"`
+	Started  time.Time     `json:"started"`
+	Stats    SessionStats  `json:"stats"`
+	Transfer *TransferInfo `json:"transfer,omitempty"`
+}
+
+// TransferInfo describes a transfer in progress.
+type TransferInfo struct {
+	Path      string `json:"path"`
+	Direction string `json:"direction"`
+	// Size is the size of the file, 0 if it is not known, as for uploads.
+	Size    int64     `json:"size,omitempty"`
+	Done    int64     `json:"done"`
+	Started time.Time `json:"started"`
+}
+
+// Info returns what s is doing. It is safe to call it while the session is
+// running.
+func (s *Session) Info() SessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := SessionInfo{
+		ID:       s.ID,
+		Protocol: s.Protocol,
+		User:     s.User,
+		Dir:      filepath.ToSlash(filepath.Join("/", s.Dir)),
+		Started:  s.Started,
+		Stats:    s.Stats(),
+	}
+	if s.Conn != nil {
+		info.Addr = s.Conn.RemoteAddr().String()
+	}
+	if p := s.transfer; p != nil {
+		info.Transfer = &TransferInfo{
+			Path:      p.path,
+			Direction: "download",
+			Size:      p.size,
+			Done:      atomic.LoadInt64(&p.done),
+			Started:   p.started,
+		}
+		if p.upload {
+			info.Transfer.Direction = "upload"
+		}
+	}
+	return info
+}
+
+// Kick ends the session by closing its connection.
+func (s *Session) Kick() {
+	if s.Conn != nil {
+		s.Conn.Close()
+	}
+}
+
+// progress is a transfer of a session in progress.
+type progress struct {
+	path    string
+	upload  bool
+	size    int64
+	started time.Time
+	done    int64
+}
+
+// startTransfer makes the transfer of the file name the current one of s.
+// size is 0 if it is not known.
+func (s *Session) startTransfer(name string, upload bool, size int64) *progress {
+	p := &progress{path: s.clientPath(name), upload: upload, size: size, started: time.Now()}
+	s.mu.Lock()
+	s.transfer = p
+	s.mu.Unlock()
+	return p
+}
+
+// endTransfer ends the transfer p, started by startTransfer.
+func (s *Session) endTransfer(p *progress) {
+	s.mu.Lock()
+	if s.transfer == p {
+		s.transfer = nil
+	}
+	s.mu.Unlock()
+}
+
+func (p *progress) add(n int) {
+	atomic.AddInt64(&p.done, int64(n))
+}
+
+// progressFile counts the bytes read from a file being downloaded.
+type progressFile struct {
+	File
+	p *progress
+}
+
+func (f progressFile) Read(b []byte) (int, error) {
+	n, err := f.File.Read(b)
+	f.p.add(n)
+	return n, err
+}
+
+func (f progressFile) ReadAt(b []byte, off int64) (int, error) {
+	n, err := f.File.ReadAt(b, off)
+	f.p.add(n)
+	return n, err
+}
+
+// progressUpload counts the bytes written to an upload.
+type progressUpload struct {
+	Upload
+	p *progress
+}
+
+func (u progressUpload) Write(b []byte) (int, error) {
+	n, err := u.Upload.Write(b)
+	u.p.add(n)
+	return n, err
+}
+
+func (u progressUpload) WriteAt(b []byte, off int64) (int, error) {
+	n, err := u.Upload.WriteAt(b, off)
+	u.p.add(n)
+	return n, err
+}
+
+// registry holds the sessions of the connected clients. The accept loops
+// add every session and remove it when its connection ends.
+type registry struct {
+	mu   sync.Mutex
+	byID map[uint64]*Session
+}
+
+var sessions = &registry{byID: make(map[uint64]*Session)}
+
+func (r *registry) add(s *Session) {
+	r.mu.Lock()
+	r.byID[s.ID] = s
+	r.mu.Unlock()
+}
+
+func (r *registry) remove(s *Session) {
+	r.mu.Lock()
+	delete(r.byID, s.ID)
+	r.mu.Unlock()
+}
+
+// Get returns the session with the given ID, nil if it is not connected.
+func (r *registry) Get(id uint64) *Session {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.byID[id]
+}
+
+// Sessions returns the connected sessions, sorted by ID.
+func (r *registry) Sessions() []*Session {
+	r.mu.Lock()
+	list := make([]*Session, 0, len(r.byID))
+	for _, s := range r.byID {
+		list = append(list, s)
+	}
+	r.mu.Unlock()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
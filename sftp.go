@@ -0,0 +1,383 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// Ways a client of the SFTP server logged in, see sshConfig.
+const (
+	sshNone      = "none"
+	sshPassword  = "password"
+	sshKey       = "key"
+	sshAnonymous = "anonymous"
+)
+
+// listenSFTP opens the listener of the SFTP server at addr and loads its host key.
+func listenSFTP(addr string) (*net.TCPListener, ssh.Signer, error) {
+	if option.HostKey == "" {
+		return nil, nil, errors.New("the SFTP server needs a host key file")
+	}
+	key, err := hostKey(option.HostKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	listener, err := net.ListenTCP("tcp", tcpAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	return listener, key, nil
+}
+
+// hostKey reads the SSH host key from the file path. A new ed25519 key is
+// stored there if the file does not exist. The file must be outside of the root.
+func hostKey(path string) (ssh.Signer, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(Root, abs); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return nil, fmt.Errorf("host key %s is inside of the root", abs)
+	}
+	data, err := ioutil.ReadFile(abs)
+	if os.IsNotExist(err) {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		block, err := ssh.MarshalPrivateKey(key, "goftp")
+		if err != nil {
+			return nil, err
+		}
+		data = pem.EncodeToMemory(block)
+		if err := ioutil.WriteFile(abs, data, 0600); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return ssh.ParsePrivateKey(data)
+}
+
+// serveSFTP accepts the connections of the SFTP server until listener is closed.
+func serveSFTP(listener *net.TCPListener, key ssh.Signer) {
+	for {
+		conn, err := listener.AcceptTCP()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		if !accepted(conn.RemoteAddr()) {
+			audit(nil, "denied", conn.RemoteAddr().String())
+			conn.Close()
+			continue
+		}
+		s := newSession(conn)
+		s.Protocol = "sftp"
+		sessions.add(s)
+		go handleSSH(s, key)
+	}
+}
+
+// sshConfig returns the configuration of the SSH handshake of s. Logins are
+// checked like the ones of pass, the way the client logged in is passed
+// on in the "login" extension of the permissions.
+func sshConfig(s *Session, key ssh.Signer) *ssh.ServerConfig {
+	config := &ssh.ServerConfig{NoClientAuth: !authRequired()}
+	config.AddHostKey(key)
+	if !authRequired() {
+		return config
+	}
+	config.PasswordCallback = func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+		if err := notBanned(s); err != nil {
+			return nil, trimError(err)
+		}
+		if isAnonymousName(c.User()) {
+			return &ssh.Permissions{Extensions: map[string]string{"login": sshAnonymous, "password": string(password)}}, nil
+		}
+		if _, err := checkPassword(s, c.User(), string(password)); err != nil {
+			return nil, trimError(err)
+		}
+		return &ssh.Permissions{Extensions: map[string]string{"login": sshPassword}}, nil
+	}
+	// a client offers all its keys, a key which does not fit is no failed login.
+	config.PublicKeyCallback = func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+		if err := notBanned(s); err != nil {
+			return nil, trimError(err)
+		}
+		u := lookupUser(c.User())
+		if u == nil || u.Disabled || !hasKey(u, key) {
+			return nil, errors.New("unknown key")
+		}
+		return &ssh.Permissions{Extensions: map[string]string{"login": sshKey}}, nil
+	}
+	return config
+}
+
+// hasKey reports whether key is one of the keys of u.
+func hasKey(u *User, key ssh.PublicKey) bool {
+	for _, k := range u.keys {
+		if bytes.Equal(k.Marshal(), key.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleSSH runs the SFTP sessions of an SSH connection.
+// sshHandshakeTimeout bounds the SSH handshake with the login in it, which
+// includes the pauses after failed passwords.
+const sshHandshakeTimeout = 2 * time.Minute
+
+func handleSSH(s *Session, key ssh.Signer) {
+	defer s.Conn.Close()
+	defer sessions.remove(s)
+	if err := notBanned(s); err != nil {
+		return
+	}
+	// a client which never finishes the handshake must not hold the connection.
+	s.Conn.SetDeadline(time.Now().Add(sshHandshakeTimeout))
+	sconn, chans, reqs, err := ssh.NewServerConn(s.Conn, sshConfig(s, key))
+	if err != nil {
+		// failed passwords are audited by checkPassword already.
+		return
+	}
+	s.Conn.SetDeadline(time.Time{})
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+	login := sshNone
+	if sconn.Permissions != nil {
+		login = sconn.Permissions.Extensions["login"]
+	}
+	switch login {
+	case sshAnonymous:
+		err = loginAnonymous(s, sconn.Permissions.Extensions["password"])
+	case sshPassword, sshKey:
+		u := lookupUser(sconn.User())
+		if u == nil {
+			err = errors.New("login incorrect")
+			break
+		}
+		if login == sshKey {
+			guard.succeeded(remoteIP(s), sconn.User())
+		}
+		err = enter(s, sconn.User(), u)
+	default:
+		err = check(func(h Hooks) error { return h.OnLogin(s) })
+		if err == nil {
+			notify(func(h Hooks) error { return h.OnLogin(s) })
+		}
+	}
+	if err != nil {
+		return
+	}
+	defer disconnected(s)
+	handler := &sftpHandler{s: s}
+	handlers := sftp.Handlers{FileGet: handler, FilePut: handler, FileCmd: handler, FileList: handler}
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, requests, err := nc.Accept()
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		go func(in <-chan *ssh.Request) {
+			for req := range in {
+				// the payload of a subsystem request is the length of the name and the name.
+				req.Reply(req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp", nil)
+			}
+		}(requests)
+		go func() {
+			server := sftp.NewRequestServer(channel, handlers)
+			if err := server.Serve(); err != nil && err != io.EOF {
+				fmt.Println("sftp error!", err)
+			}
+			server.Close()
+		}()
+	}
+}
+
+// sftpHandler serves the SFTP requests of a session through its driver,
+// with the locks, hooks and policies of the commands of the FTP side.
+type sftpHandler struct {
+	s *Session
+}
+
+// path resolves the path of a request.
+func (h *sftpHandler) path(p string) (string, error) {
+	name, err := h.s.resolve(p)
+	if err != nil {
+		return "", &os.PathError{Op: "resolve", Path: p, Err: os.ErrPermission}
+	}
+	return name, nil
+}
+
+func (h *sftpHandler) Fileread(r *sftp.Request) (io.ReaderAt, error) {
+	name, err := h.path(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return openDownload(h.s, name)
+}
+
+// Filewrite starts an upload, the file is replaced when the client closes it.
+func (h *sftpHandler) Filewrite(r *sftp.Request) (io.WriterAt, error) {
+	if r.Pflags().Append {
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+	name, err := h.path(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	return createUpload(h.s, name)
+}
+
+func (h *sftpHandler) Filecmd(r *sftp.Request) error {
+	s := h.s
+	name, err := h.path(r.Filepath)
+	if err != nil {
+		return err
+	}
+	switch r.Method {
+	case "Setstat":
+		return h.setstat(name, r)
+	case "Rename", "PosixRename":
+		to, err := h.path(r.Target)
+		if err != nil {
+			return err
+		}
+		return renameEntry(s, name, to, r.Method == "PosixRename")
+	case "Remove":
+		// deleted files go to the trash, like with rm
+		fi, err := s.FS.Lstat(name)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return errors.New(r.Filepath + " is a directory")
+		}
+		return trimError(removeEntry(s, r.Filepath, false))
+	case "Rmdir":
+		entries, err := readDir(s.FS, name)
+		if err != nil {
+			return err
+		}
+		if len(entries) > 0 {
+			return errors.New(r.Filepath + " is not empty")
+		}
+		return trimError(removeEntry(s, r.Filepath, true))
+	case "Mkdir":
+		if err := s.FS.Mkdir(name, 0755); err != nil {
+			return err
+		}
+		audit(s, "mkdir", s.clientPath(name))
+		return nil
+	}
+	// links might lead out of the root.
+	return sftp.ErrSSHFxOpUnsupported
+}
+
+// PosixRename is the rename of the posix-rename extension, which replaces
+// the target.
+func (h *sftpHandler) PosixRename(r *sftp.Request) error {
+	return h.Filecmd(r)
+}
+
+// setstat changes the mode and times of name. Sizes and owners cannot be changed.
+func (h *sftpHandler) setstat(name string, r *sftp.Request) error {
+	flags, attrs := r.AttrFlags(), r.Attributes()
+	if flags.Size || flags.UidGid {
+		return sftp.ErrSSHFxOpUnsupported
+	}
+	detail := []string{h.s.clientPath(name)}
+	if flags.Permissions {
+		mode := attrs.FileMode().Perm()
+		if err := h.s.FS.Chmod(name, mode); err != nil {
+			return err
+		}
+		detail = append(detail, fmt.Sprintf("mode %04o", mode))
+	}
+	if flags.Acmodtime {
+		mtime := time.Unix(int64(attrs.Mtime), 0)
+		if err := h.s.FS.Chtimes(name, time.Unix(int64(attrs.Atime), 0), mtime); err != nil {
+			return err
+		}
+		detail = append(detail, "mtime "+mtime.UTC().Format(time.RFC3339))
+	}
+	if len(detail) > 1 {
+		audit(h.s, "setstat", detail...)
+	}
+	return nil
+}
+
+func (h *sftpHandler) Filelist(r *sftp.Request) (sftp.ListerAt, error) {
+	name, err := h.path(r.Filepath)
+	if err != nil {
+		return nil, err
+	}
+	var fi os.FileInfo
+	switch r.Method {
+	case "List":
+		entries, err := readDir(h.s.FS, name)
+		if err != nil {
+			return nil, err
+		}
+		return listerAt(entries), nil
+	case "Stat":
+		fi, err = h.s.FS.Stat(name)
+	case "Lstat":
+		fi, err = h.s.FS.Lstat(name)
+	default:
+		return nil, sftp.ErrSSHFxOpUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+	return listerAt{fi}, nil
+}
+
+func (h *sftpHandler) Readlink(p string) (string, error) {
+	name, err := h.path(p)
+	if err != nil {
+		return "", err
+	}
+	return h.s.FS.Readlink(name)
+}
+
+// listerAt lists the entries of a directory or a single file.
+type listerAt []os.FileInfo
+
+func (l listerAt) ListAt(f []os.FileInfo, offset int64) (int, error) {
+	if offset >= int64(len(l)) {
+		return 0, io.EOF
+	}
+	n := copy(f, l[offset:])
+	if n < len(f) {
+		return n, io.EOF
+	}
+	return n, nil
+}
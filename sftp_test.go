@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestSFTP(t *testing.T) {
+	saved, oldRoot, oldStorage := *option, Root, storage
+	defer func() { *option, Root, storage = saved, oldRoot, oldStorage }()
+	option.LoginDelay, option.Versions = 0, 1
+	Root = testRoot(t, "alice/notes.txt")
+	storage = localDriver{}
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(private)
+	assert.NoError(t, err)
+	authorized := strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey())))
+	assert.NoError(t, testUsers(t, testRoot(t), `[
+		{"name": "alice", "password": "HASH", "root": "alice"},
+		{"name": "bob", "keys": ["`+authorized+`"]}
+	]`))
+	logDir := testRoot(t)
+	assert.NoError(t, openAudit(filepath.Join(logDir, "audit.log")))
+	defer func() {
+		auditLog.Lock()
+		auditLog.f.Close()
+		auditLog.f = nil
+		auditLog.Unlock()
+	}()
+
+	_, err = hostKey(filepath.Join(Root, "host_key"))
+	assert.Error(t, err, "the host key may not be served")
+	key, err := hostKey(filepath.Join(testRoot(t), "host_key"))
+	assert.NoError(t, err)
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	defer listener.Close()
+	go serveSFTP(listener, key)
+	dial := func(user string, auth ssh.AuthMethod) (*sftp.Client, error) {
+		conn, err := ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+			User:            user,
+			Auth:            []ssh.AuthMethod{auth},
+			HostKeyCallback: ssh.FixedHostKey(key.PublicKey()),
+		})
+		if err != nil {
+			return nil, err
+		}
+		client, err := sftp.NewClient(conn)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		t.Cleanup(func() { client.Close(); conn.Close() })
+		return client, nil
+	}
+
+	_, err = dial("alice", ssh.Password("wrong"))
+	assert.Error(t, err)
+	_, err = dial("alice", ssh.PublicKeys(signer))
+	assert.Error(t, err, "the key is bob's")
+	client, err := dial("alice", ssh.Password("secret"))
+	assert.NoError(t, err)
+
+	// alice is confined to her home
+	entries, err := client.ReadDir("/")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "notes.txt", entries[0].Name())
+	_, err = client.Stat("/../notes.txt")
+	assert.NoError(t, err, "paths are cleaned to the root")
+	f, err := client.Open("notes.txt")
+	assert.NoError(t, err)
+	data, err := ioutil.ReadAll(f)
+	assert.NoError(t, err)
+	assert.Equal(t, "alice/notes.txt", string(data))
+	f.Close()
+
+	// uploads, renames and deletes go through the storage, deleted files to the trash
+	assert.NoError(t, client.Mkdir("docs"))
+	f, err = client.Create("docs/a.txt")
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	data, err = ioutil.ReadFile(filepath.Join(Root, "alice/docs/a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(data))
+	mtime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	assert.NoError(t, client.Chmod("docs/a.txt", 0600))
+	assert.NoError(t, client.Chtimes("docs/a.txt", mtime, mtime))
+	fi, err := os.Stat(filepath.Join(Root, "alice/docs/a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), fi.Mode().Perm())
+	assert.True(t, fi.ModTime().Equal(mtime))
+	assert.Error(t, client.Truncate("docs/a.txt", 1), "sizes cannot be set")
+	log, err := ioutil.ReadFile(filepath.Join(logDir, "audit.log"))
+	assert.NoError(t, err)
+	assert.Regexp(t, `"event":"mkdir",.*"user":"alice",.*"detail":"/docs"`, string(log))
+	assert.Contains(t, string(log), `"detail":"/docs/a.txt mode 0600"`)
+	assert.Contains(t, string(log), `"detail":"/docs/a.txt mtime 2020-01-02T03:04:05Z"`)
+	assert.Error(t, client.Rename("docs/a.txt", "notes.txt"), "rename does not replace")
+	assert.NoError(t, client.Rename("docs/a.txt", "docs/b.txt"))
+	f, err = client.Create("docs/c.txt")
+	assert.NoError(t, err)
+	_, err = f.Write([]byte("new notes"))
+	assert.NoError(t, err)
+	assert.NoError(t, f.Close())
+	assert.NoError(t, client.PosixRename("docs/c.txt", "notes.txt"), "a posix rename replaces")
+	data, err = ioutil.ReadFile(filepath.Join(Root, "alice/notes.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new notes", string(data))
+	old, err := listVersions(storage, versionDir(storage, filepath.Join(Root, "alice/notes.txt")))
+	assert.NoError(t, err)
+	assert.Len(t, old, 1, "the replaced file is kept as a version")
+	assert.Error(t, client.Remove("docs"), "docs is not empty")
+	assert.NoError(t, client.Remove("docs/b.txt"))
+	assert.NoError(t, client.RemoveDirectory("docs"))
+	_, err = os.Stat(filepath.Join(Root, "alice/docs"))
+	assert.True(t, os.IsNotExist(err))
+	assert.Contains(t, string(trash(&Session{User: "alice", Root: filepath.Join(Root, "alice"), FS: storage}, []string{"trash", "ls"})), " /docs/b.txt\n")
+	assert.Error(t, client.Symlink("/etc/passwd", "passwd"))
+	_, err = client.Stat(metaDir)
+	assert.Error(t, err)
+
+	// bob logs in with his key
+	client, err = dial("bob", ssh.PublicKeys(signer))
+	assert.NoError(t, err)
+	entries, err = client.ReadDir("/alice")
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
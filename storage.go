@@ -0,0 +1,215 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Storage drivers.
+const (
+	StorageLocal = "local"
+	StorageDedup = "dedup"
+)
+
+// Driver stores the files a session works on. Names are the host paths
+// returned by Session.resolve, the driver decides what is kept behind them.
+type Driver interface {
+	Stat(name string) (os.FileInfo, error)
+	Lstat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Readlink(name string) (string, error)
+	Open(name string) (File, error)
+	// Create starts writing the file name, see Upload.
+	Create(name string) (Upload, error)
+	Mkdir(name string, perm os.FileMode) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Symlink(target, name string) error
+	// Remove removes a file or an empty directory.
+	Remove(name string) error
+	Rename(from, to string) error
+	// Link gives the regular file from the second name to.
+	Link(from, to string) error
+}
+
+// File is a file opened for reading.
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Closer
+	Stat() (os.FileInfo, error)
+}
+
+// Upload is a file being written. Nobody sees it until Commit moves it into
+// place according to an overwrite policy, Abort throws it away.
+type Upload interface {
+	io.Writer
+	io.WriterAt
+	Stat() (os.FileInfo, error)
+	Chmod(mode os.FileMode) error
+	Chtimes(atime, mtime time.Time) error
+	// Commit returns the name the file was stored under.
+	Commit(policy string) (string, error)
+	Abort()
+}
+
+// metaDir is the directory the server keeps its own files in, under Root and
+// every mount point. Sessions can neither reach nor list it.
+const metaDir = ".goftp"
+
+// restricted is implemented by drivers which refuse changes the modes of
+// their files would allow.
+type restricted interface {
+	// readOnly reports whether name and what is below it cannot be changed.
+	readOnly(name string) bool
+	// fixed reports whether name cannot be removed or renamed.
+	fixed(name string) bool
+}
+
+// canWrite reports whether fs lets name be changed, as far as the driver
+// and not the mode of name decide.
+func canWrite(fs Driver, name string) bool {
+	r, ok := fs.(restricted)
+	return !ok || !r.readOnly(name)
+}
+
+// canRemove reports whether fs lets name be removed or renamed, as far as
+// the driver decides.
+func canRemove(fs Driver, name string) bool {
+	r, ok := fs.(restricted)
+	return !ok || !r.fixed(name)
+}
+
+// isMeta reports whether the path name is a metaDir or inside of one.
+func isMeta(name string) bool {
+	rel, err := filepath.Rel(Root, name)
+	if err != nil {
+		return false
+	}
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		if elem == metaDir {
+			return true
+		}
+	}
+	return false
+}
+
+// readDir reads the directory name without metaDir.
+func readDir(fs Driver, name string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(name)
+	if err != nil {
+		return nil, err
+	}
+	list := entries[:0]
+	for _, v := range entries {
+		if !isMeta(filepath.Join(name, v.Name())) {
+			list = append(list, v)
+		}
+	}
+	return list, nil
+}
+
+// mkdirAll creates the directory name and all missing parents.
+func mkdirAll(fs Driver, name string, perm os.FileMode) error {
+	if fi, err := fs.Stat(name); err == nil {
+		if fi.IsDir() {
+			return nil
+		}
+		return fmt.Errorf("%s is no directory", name)
+	}
+	if parent := filepath.Dir(name); parent != name {
+		if err := mkdirAll(fs, parent, perm); err != nil {
+			return err
+		}
+	}
+	if err := fs.Mkdir(name, perm); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return nil
+}
+
+// removeAll removes name and everything below it.
+func removeAll(fs Driver, name string) error {
+	fi, err := fs.Lstat(name)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		entries, err := fs.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, v := range entries {
+			if err := removeAll(fs, filepath.Join(name, v.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return fs.Remove(name)
+}
+
+// storage is the driver of new sessions.
+var storage Driver = localDriver{}
+
+// newDriver returns the driver selected by option.
+func newDriver() (Driver, error) {
+	switch option.Storage {
+	case StorageLocal:
+		return localDriver{}, nil
+	case StorageDedup:
+		if option.BlobDir == "" {
+			return nil, fmt.Errorf("the %s storage needs a blob directory", StorageDedup)
+		}
+		return newDedupDriver(Root, option.BlobDir)
+	}
+	return nil, fmt.Errorf("unknown storage %s", option.Storage)
+}
+
+// newStorage returns the driver of the namespace: the driver selected by
+// option, with the mounts of option.Mounts over it.
+func newStorage() (Driver, error) {
+	fs, err := newDriver()
+	if err != nil || option.Mounts == "" {
+		return fs, err
+	}
+	return loadMounts(option.Mounts, fs)
+}
+
+// localDriver keeps the files as they are in the host filesystem.
+type localDriver struct{}
+
+func (localDriver) Stat(name string) (os.FileInfo, error)      { return os.Stat(name) }
+func (localDriver) Lstat(name string) (os.FileInfo, error)     { return os.Lstat(name) }
+func (localDriver) ReadDir(name string) ([]os.FileInfo, error) { return ioutil.ReadDir(name) }
+func (localDriver) Readlink(name string) (string, error)       { return os.Readlink(name) }
+func (localDriver) Mkdir(name string, perm os.FileMode) error  { return os.Mkdir(name, perm) }
+func (localDriver) Chmod(name string, mode os.FileMode) error  { return os.Chmod(name, mode) }
+func (localDriver) Symlink(target, name string) error          { return os.Symlink(target, name) }
+func (localDriver) Remove(name string) error                   { return os.Remove(name) }
+func (localDriver) Rename(from, to string) error               { return os.Rename(from, to) }
+func (localDriver) Link(from, to string) error                 { return os.Link(from, to) }
+
+func (localDriver) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (localDriver) Open(name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (localDriver) Create(name string) (Upload, error) {
+	f, err := createAtomic(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
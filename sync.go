@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/moshuipan/goftp/delta"
+	"github.com/moshuipan/goftp/transfer"
+)
+
+// syncFile updates a file with the rsync algorithm, see package delta. The
+// server sends the signature of its copy, the client answers with the delta
+// of its version and the sha256 of it. The new version is built in a
+// temporary file and replaces the old one once its checksum matches.
+func syncFile(s *Session, args []string) (err error) {
+	//sync [-z codec] dst src
+	conn := s.Conn
+	args, opts, err := transferArgs(args, s.Codec)
+	if err != nil {
+		return refuse(conn, err)
+	}
+	if len(args) != 3 {
+		return refuse(conn, errors.New("sync [-z codec] dst src\n"))
+	}
+	dir, err := s.resolve(args[1])
+	if err != nil {
+		return refuse(conn, err)
+	}
+	_, filename := filepath.Split(args[2])
+	name := filepath.Join(dir, filename)
+	defer func() {
+		if err != nil {
+			uploadFailed(s, s.fileEvent(name, nil), err)
+		}
+	}()
+	unlock, err := locks.lock(s, name, true)
+	if err != nil {
+		return refuse(conn, err)
+	}
+	defer unlock()
+	var base io.ReaderAt = strings.NewReader("")
+	sig := &delta.Signature{BlockSize: delta.MinBlockSize}
+	old, err := s.FS.Open(name)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return refuse(conn, errors.New(err.Error()+"\n"))
+	default:
+		defer old.Close()
+		fi, err := old.Stat()
+		if err != nil {
+			return refuse(conn, errors.New(err.Error()+"\n"))
+		}
+		if fi.IsDir() {
+			return refuse(conn, errors.New(args[1]+"/"+filename+" is a directory\n"))
+		}
+		if sig, err = delta.NewSignature(old, delta.BlockSize(fi.Size())); err != nil {
+			return refuse(conn, errors.New(err.Error()+"\n"))
+		}
+		base = old
+	}
+	f, err := s.FS.Create(name)
+	if err != nil {
+		return refuse(conn, errors.New(err.Error()+"\n"))
+	}
+	defer f.Abort()
+	p := s.startTransfer(name, true, 0)
+	defer s.endTransfer(p)
+	h := &transfer.Header{Codec: codecFor(filename, opts.codec), Digest: verifyHash, Size: sig.Size}
+	if err := transfer.WriteHeader(conn, h); err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	fw := transfer.NewWriter(conn)
+	if _, err := sig.WriteTo(fw); err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	if err := fw.Close(); err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	sum, err := transfer.NewHash(h.Digest)
+	if err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	fr := transfer.NewReader(s.reader)
+	zr, err := transfer.NewDecompressor(fr, h.Codec)
+	if err == nil {
+		_, err = delta.Patch(io.MultiWriter(progressUpload{Upload: f, p: p}, sum), base, sig, zr)
+		zr.Close()
+	}
+	// the whole delta is read even if it cannot be applied.
+	if _, rerr := io.Copy(ioutil.Discard, fr); rerr != nil {
+		return errors.New(rerr.Error() + "\n")
+	}
+	if derr := transfer.CheckDigest(s.reader, sum.Sum(nil)); err == nil {
+		err = derr
+	}
+	if err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	if err := check(func(h Hooks) error { return h.OnUpload(s, s.fileEvent(name, fi)) }); err != nil {
+		return err
+	}
+	// sync updates the file, it is replaced whatever the overwrite policy.
+	final, err := s.commit(f, name, OverwriteReplace)
+	if err != nil {
+		return err
+	}
+	s.received(fi.Size())
+	notify(func(h Hooks) error { return h.OnUpload(s, s.fileEvent(final, fi)) })
+	return nil
+}
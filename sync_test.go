@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"path/filepath"
+	"testing"
+
+	"github.com/moshuipan/goftp/delta"
+	"github.com/moshuipan/goftp/transfer"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncClient runs the client side of sync: it reads the signature the server
+// sends and answers with the delta of data against it.
+func syncClient(t *testing.T, conn io.ReadWriter, data []byte) (*delta.Signature, delta.Stats) {
+	h, err := transfer.ReadHeader(conn)
+	assert.NoError(t, err)
+	fr := transfer.NewReader(conn)
+	sig, err := delta.ReadSignature(fr)
+	assert.NoError(t, err)
+	_, err = io.Copy(ioutil.Discard, fr)
+	assert.NoError(t, err)
+	assert.Equal(t, sig.Size, h.Size)
+
+	fw := transfer.NewWriter(conn)
+	zw, err := transfer.NewCompressor(fw, h.Codec, -1)
+	assert.NoError(t, err)
+	stats, err := delta.Diff(zw, bytes.NewReader(data), sig)
+	assert.NoError(t, err)
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, fw.Close())
+	sum, err := transfer.NewHash(h.Digest)
+	assert.NoError(t, err)
+	sum.Write(data)
+	assert.NoError(t, transfer.WriteDigest(conn, sum.Sum(nil)))
+	return sig, stats
+}
+
+func TestSync(t *testing.T) {
+	saved, oldRoot, oldStorage := *option, Root, storage
+	defer func() { *option, Root, storage = saved, oldRoot, oldStorage }()
+	option.Overwrite, option.Versions = OverwriteFail, 0
+	Root = testRoot(t, "in/x")
+	storage = localDriver{}
+	rnd := rand.New(rand.NewSource(1))
+	old := make([]byte, 64*1024)
+	rnd.Read(old)
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(Root, "in/data.bin"), old, 0644))
+
+	server, client := testConn(t)
+	s := newSession(server)
+	run := func(name string, data []byte) (*delta.Signature, delta.Stats) {
+		done := make(chan error, 1)
+		go func() { done <- syncFile(s, []string{SYNC, "in", name}) }()
+		sig, stats := syncClient(t, client, data)
+		assert.NoError(t, <-done)
+		assert.Equal(t, data, []byte(readFile(t, filepath.Join(Root, "in", name))))
+		return sig, stats
+	}
+
+	// one changed block, the file is replaced whatever the overwrite policy.
+	changed := append([]byte(nil), old...)
+	copy(changed[20000:], "changed")
+	sig, stats := run("data.bin", changed)
+	assert.Equal(t, int64(len(old)), sig.Size)
+	assert.True(t, stats.Literal > 0 && stats.Literal <= int64(sig.BlockSize), "only the changed block is sent")
+
+	// an appended tail.
+	appended := append(append([]byte(nil), changed...), "the tail"...)
+	_, stats = run("data.bin", appended)
+	assert.Equal(t, int64(len(changed)), stats.Matched)
+	assert.Equal(t, int64(len("the tail")), stats.Literal)
+
+	// a missing target is sent whole.
+	sig, stats = run("new.bin", old)
+	assert.Equal(t, int64(0), sig.Size)
+	assert.Equal(t, int64(0), stats.Matched)
+	assert.Equal(t, int64(len(old)), stats.Literal)
+
+	tmp, _ := filepath.Glob(filepath.Join(Root, "in", ".*.tmp"))
+	assert.Empty(t, tmp)
+}
@@ -1,18 +1,27 @@
+//go:build ignore
+
+// testcli is a small client for the goftp server, run it with `go run testcli.go`.
 package main
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"os"
 	"path/filepath"
-	"strings"
+
+	"github.com/moshuipan/goftp/delta"
+	"github.com/moshuipan/goftp/transfer"
 )
 
 var clock chan bool
 
+var errRefused = errors.New("transfer refused")
+
 type Buffer []byte
 
 func (this *Buffer) Write(w []byte) {
@@ -40,62 +49,70 @@ func main() {
 			if err != nil {
 				fmt.Println(err, ok)
 			}
-			if args := strings.Fields(fmt.Sprintf("%s", s)); len(args) <= 0 {
+			args, literal, err := transfer.SplitQuoted(string(s))
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			if len(args) <= 0 {
 				continue
 			}
-			if args := strings.Fields(fmt.Sprintf("%s", s)); args[0] == "ul" {
-				if len(args) != 3 {
-					fmt.Println("ul dst src")
+			// the server reads one command per line.
+			s = []byte(string(s) + "\n")
+			if args[0] == "ul" {
+				if len(args) < 3 || len(args)%2 == 0 {
+					fmt.Println("ul [-z codec] [-n streams] dst src")
 					continue
 				}
-				f, err := os.Open(args[2])
+				f, err := os.Open(args[len(args)-1])
 				if err != nil {
 					fmt.Println(err)
 					continue
 				}
 				conn.Write(s)
-				buf := make([]byte, 1024)
-				var start int64 = 0
-				for {
-					n, err := f.ReadAt(buf, start)
-					if err != nil {
-						if err != io.EOF {
-							fmt.Println("read file error!", err)
-							break
-						}
-					}
-					start += int64(n)
-					b := buf[0:n]
-					if len(b) == 1 {
-						b = append(b, 0xda)
-					}
-					if len(b) == 0 {
-						fmt.Println("read all file!")
-						break
-					}
-					_, err = conn.Write(b)
-					if err != nil {
-						fmt.Println("send file error!", err)
-						break
-					}
+				if err := upload(conn, f); err != nil && err != errRefused {
+					fmt.Println(err)
 				}
-				_, err = conn.Write([]byte{0xda})
+				f.Close()
+				clock <- true
+				continue
+			}
+			if args[0] == "sync" {
+				if len(args) < 3 || len(args)%2 == 0 {
+					fmt.Println("sync [-z codec] dst src")
+					continue
+				}
+				f, err := os.Open(args[len(args)-1])
 				if err != nil {
-					fmt.Println("send file error!")
-					break
+					fmt.Println(err)
+					continue
+				}
+				conn.Write(s)
+				if err := syncFile(conn, f); err != nil && err != errRefused {
+					fmt.Println(err)
 				}
 				f.Close()
 				clock <- true
-				// conn.CloseWrite()
 				continue
 			}
-			if args := strings.Fields(fmt.Sprintf("%s", s)); args[0] == "dl" {
-				if len(args) != 3 {
-					fmt.Println("dl dst src")
+			if args[0] == "dl" {
+				if len(args) < 3 || len(args)%2 == 0 {
+					fmt.Println("dl [-z codec] [-n streams] dst src")
 					continue
 				}
-				_, filename := filepath.Split(args[2])
-				name := filepath.Join(args[1], filename)
+				if transfer.HasGlob(args[len(args)-1]) && !literal[len(args)-1] {
+					_, err = conn.Write(s)
+					if err == nil {
+						err = downloadAll(conn, args[len(args)-2])
+					}
+					if err != nil && err != errRefused {
+						fmt.Println(err)
+					}
+					clock <- true
+					continue
+				}
+				_, filename := filepath.Split(args[len(args)-1])
+				name := filepath.Join(args[len(args)-2], filename)
 				f, err := os.Create(name)
 				if err != nil {
 					fmt.Println(err)
@@ -107,30 +124,14 @@ func main() {
 					f.Close()
 					continue
 				}
-				buf := make([]byte, 1024)
-				for {
-					n, err := conn.Read(buf)
-					// _, err = io.Copy(f, conn)
-					if err != nil {
+				err = download(conn, f)
+				f.Close()
+				if err != nil {
+					if err != errRefused {
 						fmt.Println(err)
-						break
-					}
-					b := buf[0:n]
-					if len(b) == 1 && b[0] == 0xda {
-						fmt.Println("download end!")
-						break
-					} else {
-						if len(b) == 2 && b[1] == 0xda {
-							b = b[0:0]
-						}
-						_, err = f.Write(b)
-						if err != nil {
-							fmt.Println(err)
-							break
-						}
 					}
+					os.Remove(name)
 				}
-				f.Close()
 				clock <- true
 				continue
 			}
@@ -146,6 +147,182 @@ func main() {
 	<-exit
 }
 
+// upload waits for the server to accept the transfer and sends f.
+func upload(conn net.Conn, f *os.File) error {
+	h, err := transfer.ReadHeader(conn)
+	if err != nil {
+		return refused(h, err)
+	}
+	if h.Streams > 0 {
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		ranges := transfer.Ranges(fi.Size(), h.Streams)
+		err = parallel(conn, h, func(i int, c net.Conn) error {
+			return transfer.SendRange(c, f, ranges[i][0], ranges[i][1], h, -1)
+		})
+		if err != nil {
+			fmt.Println("send file error!")
+			return err
+		}
+	} else if err := transfer.Send(conn, f, h, -1); err != nil {
+		fmt.Println("send file error!")
+		return err
+	}
+	fmt.Println("read all file!")
+	return nil
+}
+
+// download receives a file from the server into f.
+// With verify on, the file is only kept if its checksum matches.
+func download(conn net.Conn, f *os.File) error {
+	h, err := transfer.ReadHeader(conn)
+	if err != nil {
+		return refused(h, err)
+	}
+	if err := receive(conn, h, f); err != nil {
+		return err
+	}
+	fmt.Println("download end!")
+	return nil
+}
+
+// downloadAll receives the files matching a pattern into the directory dir.
+func downloadAll(conn net.Conn, dir string) error {
+	h, err := transfer.ReadHeader(conn)
+	if err != nil {
+		return refused(h, err)
+	}
+	for _, name := range h.Files {
+		fh, err := transfer.ReadHeader(conn)
+		if err != nil {
+			if fh == nil {
+				return err
+			}
+			// the server reports the reason itself.
+			continue
+		}
+		local := filepath.Join(dir, filepath.Base(name))
+		f, err := os.Create(local)
+		if err != nil {
+			fmt.Println(err)
+			// the file must be received anyway to get to the next one.
+			if f, err = os.OpenFile(os.DevNull, os.O_WRONLY, 0); err != nil {
+				return err
+			}
+			receive(conn, fh, f)
+			f.Close()
+			continue
+		}
+		err = receive(conn, fh, f)
+		f.Close()
+		if err != nil {
+			fmt.Println(name+":", err)
+			os.Remove(local)
+			continue
+		}
+		fmt.Println(name)
+	}
+	fmt.Println("download end!")
+	return nil
+}
+
+// receive receives the data of a transfer announced by h into f.
+func receive(conn net.Conn, h *transfer.Header, f *os.File) error {
+	if h.Streams > 0 {
+		err := parallel(conn, h, func(i int, c net.Conn) error {
+			return transfer.ReceiveRange(f, c, h)
+		})
+		if err != nil {
+			return err
+		}
+		if fi, err := f.Stat(); err != nil || fi.Size() != h.Size {
+			return errors.New("download incomplete")
+		}
+		return nil
+	}
+	return transfer.Receive(f, conn, h)
+}
+
+// parallel opens the data connections announced in h and runs fn for each of them concurrently.
+func parallel(conn net.Conn, h *transfer.Header, fn func(i int, c net.Conn) error) error {
+	addr := &net.TCPAddr{IP: conn.RemoteAddr().(*net.TCPAddr).IP, Port: h.Port}
+	errs := make(chan error, h.Streams)
+	for i := 0; i < h.Streams; i++ {
+		go func(i int) {
+			c, err := net.DialTCP("tcp", nil, addr)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer c.Close()
+			if _, err := c.Write([]byte(h.Token)); err != nil {
+				errs <- err
+				return
+			}
+			errs <- fn(i, c)
+		}(i)
+	}
+	var err error
+	for i := 0; i < h.Streams; i++ {
+		if e := <-errs; e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// refused maps a transfer refused by the server to errRefused,
+// the server prints the reason itself.
+// syncFile sends the delta of f against the signature of the server's copy.
+func syncFile(conn net.Conn, f *os.File) error {
+	h, err := transfer.ReadHeader(conn)
+	if err != nil {
+		return refused(h, err)
+	}
+	fr := transfer.NewReader(conn)
+	sig, err := delta.ReadSignature(fr)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ioutil.Discard, fr); err != nil {
+		return err
+	}
+	sum, err := transfer.NewHash(h.Digest)
+	if err != nil {
+		return err
+	}
+	fw := transfer.NewWriter(conn)
+	zw, err := transfer.NewCompressor(fw, h.Codec, -1)
+	if err != nil {
+		fw.Abort()
+		return err
+	}
+	stats, err := delta.Diff(zw, io.TeeReader(f, sum), sig)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		fw.Abort()
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+	if err := transfer.WriteDigest(conn, sum.Sum(nil)); err != nil {
+		return err
+	}
+	fmt.Printf("sync: %d bytes matched, %d bytes sent\n", stats.Matched, stats.Literal)
+	return nil
+}
+func refused(h *transfer.Header, err error) error {
+	if h != nil {
+		return errRefused
+	}
+	return err
+}
+
 func mustCopy(dst io.Writer, src net.Conn) {
 	buf := make([]byte, 1024)
 	for {
@@ -0,0 +1,80 @@
+package transfer
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs supported for the data stream.
+const (
+	None    = "none"
+	Deflate = "deflate"
+	Gzip    = "gzip"
+	Zstd    = "zstd"
+)
+
+// ValidCodec reports whether codec is one of the supported codecs.
+func ValidCodec(codec string) bool {
+	switch codec {
+	case None, Deflate, Gzip, Zstd:
+		return true
+	}
+	return false
+}
+
+// NewCompressor wraps w so that everything written is encoded with codec.
+// A negative level selects the default level of the codec.
+// The returned writer must be closed to flush the encoder; closing it does not close w.
+func NewCompressor(w io.Writer, codec string, level int) (io.WriteCloser, error) {
+	switch codec {
+	case None, "":
+		return nopWriteCloser{w}, nil
+	case Deflate:
+		if level < 0 {
+			level = flate.DefaultCompression
+		}
+		return flate.NewWriter(w, level)
+	case Gzip:
+		if level < 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case Zstd:
+		opts := []zstd.EOption{}
+		if level >= 0 {
+			opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+		}
+		return zstd.NewWriter(w, opts...)
+	}
+	return nil, fmt.Errorf("unknown codec %q", codec)
+}
+
+// NewDecompressor wraps r so that data encoded with codec is decoded.
+func NewDecompressor(r io.Reader, codec string) (io.ReadCloser, error) {
+	switch codec {
+	case None, "":
+		return ioutil.NopCloser(r), nil
+	case Deflate:
+		return flate.NewReader(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		d, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("unknown codec %q", codec)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
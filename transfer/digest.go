@@ -0,0 +1,55 @@
+package transfer
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// NewHash returns the hash function called name: md5, sha1, sha256 or sha512.
+func NewHash(name string) (hash.Hash, error) {
+	switch strings.ToLower(strings.Replace(name, "-", "", 1)) {
+	case "md5":
+		return md5.New(), nil
+	case "sha1":
+		return sha1.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "sha512":
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unknown hash %q", name)
+}
+
+// WriteDigest sends sum as a frame stream, it follows the data of a verified transfer.
+func WriteDigest(w io.Writer, sum []byte) error {
+	fw := NewWriter(w)
+	if _, err := io.WriteString(fw, hex.EncodeToString(sum)); err != nil {
+		return err
+	}
+	return fw.Close()
+}
+
+// CheckDigest reads a digest written by WriteDigest and compares it with sum.
+// No more than the size of sum is kept, the rest of a longer digest is skipped.
+func CheckDigest(r io.Reader, sum []byte) error {
+	fr := NewReader(r)
+	b, err := ioutil.ReadAll(io.LimitReader(fr, int64(hex.EncodedLen(len(sum)))+1))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(ioutil.Discard, fr); err != nil {
+		return err
+	}
+	if want := hex.EncodeToString(sum); string(b) != want {
+		return fmt.Errorf("checksum mismatch: sent %s, received %s", b, want)
+	}
+	return nil
+}
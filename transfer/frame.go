@@ -0,0 +1,123 @@
+// Package transfer implements the parts of the goftp protocol shared by the server
+// and the client: command lines and the data stream of transfers.
+//
+// Commands are sent as lines, see ReadLine and SplitArgs. A data stream is a sequence of frames. Every frame starts with a 4-byte big-endian
+// length followed by that many bytes of payload. A zero length frame ends the
+// stream, and the abortMarker length tells the receiver that the sender gave up
+// in the middle of a transfer.
+package transfer
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// MaxFrameSize is the largest payload written in a single frame.
+	MaxFrameSize = 64 * 1024
+
+	abortMarker = 0xffffffff
+)
+
+// ErrAborted is returned by Reader when the peer aborted the stream.
+var ErrAborted = errors.New("transfer aborted by peer")
+
+// Writer splits everything written to it into frames.
+type Writer struct {
+	w   io.Writer
+	hdr [4]byte
+}
+
+// NewWriter returns a Writer writing frames to w.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: w}
+}
+
+// Write writes p as one or more frames.
+func (fw *Writer) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > MaxFrameSize {
+			chunk = chunk[:MaxFrameSize]
+		}
+		if err := fw.writeLength(uint32(len(chunk))); err != nil {
+			return written, err
+		}
+		n, err := fw.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[len(chunk):]
+	}
+	return written, nil
+}
+
+// Close ends the stream. It does not close the underlying writer.
+func (fw *Writer) Close() error {
+	return fw.writeLength(0)
+}
+
+// Abort tells the receiver that the stream is incomplete.
+func (fw *Writer) Abort() error {
+	return fw.writeLength(abortMarker)
+}
+
+func (fw *Writer) writeLength(n uint32) error {
+	binary.BigEndian.PutUint32(fw.hdr[:], n)
+	_, err := fw.w.Write(fw.hdr[:])
+	return err
+}
+
+// Reader reads the payload of a frame stream. It never reads past the end of the
+// stream, so the underlying reader can be used again afterwards.
+type Reader struct {
+	r      io.Reader
+	remain uint32
+	err    error
+}
+
+// NewReader returns a Reader reading frames from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Read reads payload bytes. It returns io.EOF at the end of the stream and
+// ErrAborted if the sender aborted it.
+func (fr *Reader) Read(p []byte) (int, error) {
+	for fr.remain == 0 {
+		if fr.err != nil {
+			return 0, fr.err
+		}
+		var hdr [4]byte
+		if _, err := io.ReadFull(fr.r, hdr[:]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			fr.err = err
+			return 0, err
+		}
+		switch n := binary.BigEndian.Uint32(hdr[:]); n {
+		case 0:
+			fr.err = io.EOF
+		case abortMarker:
+			fr.err = ErrAborted
+		default:
+			fr.remain = n
+		}
+	}
+	if uint32(len(p)) > fr.remain {
+		p = p[:fr.remain]
+	}
+	n, err := fr.r.Read(p)
+	fr.remain -= uint32(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		fr.err = err
+	}
+	return n, err
+}
@@ -0,0 +1,56 @@
+package transfer
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// Header is sent by the server before the data of every transfer. It tells the
+// client how the data that follows is encoded, or why the transfer was refused.
+// When Digest names a hash, the sender of the data writes the digest of the
+// plain content after the data, see WriteDigest.
+//
+// For a parallel transfer Streams is the number of data connections the client
+// opens to Port. Each of them starts with Token and then carries one range of
+// the file, see SendRange. Size is the size of a downloaded file.
+//
+// A download of a pattern starts with a header listing the matching Files,
+// followed by a header and the data for each of them.
+type Header struct {
+	Files   []string `json:"files,omitempty"`
+	Codec   string   `json:"codec,omitempty"`
+	Digest  string   `json:"digest,omitempty"`
+	Streams int      `json:"streams,omitempty"`
+	Port    int      `json:"port,omitempty"`
+	Token   string   `json:"token,omitempty"`
+	Size    int64    `json:"size,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// WriteHeader writes h as a frame stream.
+func WriteHeader(w io.Writer, h *Header) error {
+	fw := NewWriter(w)
+	if err := json.NewEncoder(fw).Encode(h); err != nil {
+		return err
+	}
+	return fw.Close()
+}
+
+// ReadHeader reads a header written by WriteHeader. A header carrying an error
+// is returned together with that error.
+func ReadHeader(r io.Reader) (*Header, error) {
+	fr := NewReader(r)
+	h := &Header{}
+	if err := json.NewDecoder(fr).Decode(h); err != nil {
+		return nil, err
+	}
+	if _, err := io.Copy(ioutil.Discard, fr); err != nil {
+		return nil, err
+	}
+	if h.Error != "" {
+		return h, errors.New(h.Error)
+	}
+	return h, nil
+}
@@ -0,0 +1,124 @@
+package transfer
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+)
+
+var (
+	// ErrLineTooLong is returned by ReadLine for lines longer than the limit.
+	ErrLineTooLong = errors.New("line too long")
+	// ErrUnterminatedQuote is returned by SplitArgs when a quote is not closed.
+	ErrUnterminatedQuote = errors.New("unterminated quote")
+	// ErrTrailingBackslash is returned by SplitArgs when a line ends with a backslash.
+	ErrTrailingBackslash = errors.New("backslash at end of line")
+)
+
+// ReadLine reads a command line terminated by LF or CRLF and returns it without
+// the terminator. Lines longer than max bytes are skipped and ErrLineTooLong is
+// returned, so the next call starts with the next line.
+func ReadLine(r *bufio.Reader, max int) (string, error) {
+	var line []byte
+	tooLong := false
+	for {
+		b, err := r.ReadSlice('\n')
+		if !tooLong {
+			line = append(line, b...)
+			if len(line) > max+2 {
+				tooLong, line = true, nil
+			}
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		break
+	}
+	if tooLong {
+		return "", ErrLineTooLong
+	}
+	s := strings.TrimSuffix(strings.TrimSuffix(string(line), "\n"), "\r")
+	if len(s) > max {
+		return "", ErrLineTooLong
+	}
+	return s, nil
+}
+
+// SplitArgs splits a command line into arguments the way a shell does:
+// arguments are separated by blanks, 'single quotes' keep everything literally,
+// "double quotes" keep blanks and a backslash escapes the next character
+// outside single quotes.
+func SplitArgs(line string) ([]string, error) {
+	args, _, err := SplitQuoted(line)
+	return args, err
+}
+
+// SplitQuoted splits line like SplitArgs and also reports for every argument
+// whether it is literal: a quoted or escaped *, ? or [ makes it a name rather
+// than a pattern, as it does in a shell.
+func SplitQuoted(line string) (args []string, literal []bool, err error) {
+	var (
+		arg   strings.Builder
+		inArg bool
+		lit   bool
+		quote rune
+		esc   bool
+	)
+	keep := func(c rune) {
+		arg.WriteRune(c)
+		lit = lit || strings.ContainsRune(globChars, c)
+	}
+	for _, c := range line {
+		switch {
+		case esc:
+			keep(c)
+			esc = false
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				keep(c)
+			}
+		case c == '\\':
+			esc, inArg = true, true
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else {
+				keep(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t':
+			if inArg {
+				args, literal = append(args, arg.String()), append(literal, lit)
+				arg.Reset()
+				inArg, lit = false, false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+	if esc {
+		return nil, nil, ErrTrailingBackslash
+	}
+	if quote != 0 {
+		return nil, nil, ErrUnterminatedQuote
+	}
+	if inArg {
+		args, literal = append(args, arg.String()), append(literal, lit)
+	}
+	return args, literal, nil
+}
+
+const globChars = "*?["
+
+// HasGlob reports whether the argument s is a pattern, which the server expands
+// to the names of the matching files.
+func HasGlob(s string) bool {
+	return strings.ContainsAny(s, globChars)
+}
@@ -0,0 +1,66 @@
+package transfer
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadLine(t *testing.T) {
+	long := strings.Repeat("x", 5000)
+	r := bufio.NewReaderSize(strings.NewReader("ls -l\r\ncd dir\n"+long+"\nls\n\nlast"), 16)
+
+	for _, want := range []string{"ls -l", "cd dir"} {
+		line, err := ReadLine(r, 4096)
+		assert.NoError(t, err)
+		assert.Equal(t, want, line)
+	}
+	_, err := ReadLine(r, 4096)
+	assert.Equal(t, ErrLineTooLong, err)
+	line, err := ReadLine(r, 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, "ls", line)
+	line, err = ReadLine(r, 4096)
+	assert.NoError(t, err)
+	assert.Equal(t, "", line)
+	_, err = ReadLine(r, 4096)
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestSplitArgs(t *testing.T) {
+	cases := []struct {
+		line string
+		args []string
+		err  error
+	}{
+		{"", nil, nil},
+		{"   ", nil, nil},
+		{"ls -l  dir", []string{"ls", "-l", "dir"}, nil},
+		{`ul in "my file.txt"`, []string{"ul", "in", "my file.txt"}, nil},
+		{`ul in 'a "b" c'`, []string{"ul", "in", `a "b" c`}, nil},
+		{`cd my\ dir`, []string{"cd", "my dir"}, nil},
+		{`echo "a\"b" 'c\d'`, []string{"echo", `a"b`, `c\d`}, nil},
+		{`cp "" x`, []string{"cp", "", "x"}, nil},
+		{`a"b c"d`, []string{"ab cd"}, nil},
+		{`ls "dir`, nil, ErrUnterminatedQuote},
+		{`ls dir\`, nil, ErrTrailingBackslash},
+	}
+	for _, c := range cases {
+		args, err := SplitArgs(c.line)
+		assert.Equal(t, c.err, err, c.line)
+		assert.Equal(t, c.args, args, c.line)
+	}
+}
+
+func TestSplitQuoted(t *testing.T) {
+	args, literal, err := SplitQuoted(`dl out *.txt "*.txt" '[a]' \? "my dir"/*.csv`)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dl", "out", "*.txt", "*.txt", "[a]", "?", "my dir/*.csv"}, args)
+	assert.Equal(t, []bool{false, false, false, true, true, true, false}, literal)
+	_, literal, err = SplitQuoted(`ls 'dir`)
+	assert.Equal(t, ErrUnterminatedQuote, err)
+	assert.Nil(t, literal)
+}
@@ -0,0 +1,59 @@
+package transfer
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Ranges splits size bytes into n consecutive [offset, offset+length) ranges.
+// Files smaller than n bytes get some empty ranges.
+func Ranges(size int64, n int) [][2]int64 {
+	if n < 1 {
+		n = 1
+	}
+	ranges := make([][2]int64, 0, n)
+	chunk := size / int64(n)
+	var off int64
+	for i := 0; i < n; i++ {
+		length := chunk
+		if i == n-1 {
+			length = size - off
+		}
+		ranges = append(ranges, [2]int64{off, length})
+		off += length
+	}
+	return ranges
+}
+
+// SendRange sends length bytes of r starting at off over one data connection of
+// a parallel transfer. The offset goes first so that the receiver knows where
+// the data belongs.
+func SendRange(w io.Writer, r io.ReaderAt, off, length int64, h *Header, level int) error {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(off))
+	if _, err := w.Write(b[:]); err != nil {
+		return err
+	}
+	return Send(w, io.NewSectionReader(r, off, length), h, level)
+}
+
+// ReceiveRange receives a range written by SendRange and writes it to w at its offset.
+func ReceiveRange(w io.WriterAt, r io.Reader, h *Header) error {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return err
+	}
+	return Receive(&offsetWriter{w: w, off: int64(binary.BigEndian.Uint64(b[:]))}, r, h)
+}
+
+// offsetWriter turns sequential writes into writes at increasing offsets.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (ow *offsetWriter) Write(p []byte) (int, error) {
+	n, err := ow.w.WriteAt(p, ow.off)
+	ow.off += int64(n)
+	return n, err
+}
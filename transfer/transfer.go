@@ -0,0 +1,76 @@
+package transfer
+
+import (
+	"hash"
+	"io"
+	"io/ioutil"
+)
+
+// Send writes the content of r to w as the data of a transfer described by h.
+// level is the compression level, see NewCompressor.
+func Send(w io.Writer, r io.Reader, h *Header, level int) error {
+	fw := NewWriter(w)
+	var sum hash.Hash
+	if h.Digest != "" {
+		var err error
+		if sum, err = NewHash(h.Digest); err != nil {
+			fw.Abort()
+			return err
+		}
+		r = io.TeeReader(r, sum)
+	}
+	zw, err := NewCompressor(fw, h.Codec, level)
+	if err != nil {
+		fw.Abort()
+		return err
+	}
+	if _, err := io.Copy(zw, r); err != nil {
+		fw.Abort()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		fw.Abort()
+		return err
+	}
+	if err := fw.Close(); err != nil {
+		return err
+	}
+	if sum != nil {
+		return WriteDigest(w, sum.Sum(nil))
+	}
+	return nil
+}
+
+// Receive reads the data of a transfer described by h from r and writes it to w.
+// The whole transfer is consumed from r even if writing to w fails, so that r
+// can be used for the next command.
+func Receive(w io.Writer, r io.Reader, h *Header) error {
+	var sum hash.Hash
+	var err error
+	if h.Digest != "" {
+		if sum, err = NewHash(h.Digest); err == nil {
+			w = io.MultiWriter(w, sum)
+		}
+	}
+	fr := NewReader(r)
+	if err == nil {
+		var zr io.ReadCloser
+		if zr, err = NewDecompressor(fr, h.Codec); err == nil {
+			_, err = io.Copy(w, zr)
+			zr.Close()
+		}
+	}
+	if _, rerr := io.Copy(ioutil.Discard, fr); rerr != nil {
+		// the sender gave up, no digest follows.
+		return rerr
+	}
+	switch {
+	case sum != nil:
+		if derr := CheckDigest(r, sum.Sum(nil)); err == nil {
+			err = derr
+		}
+	case h.Digest != "":
+		io.Copy(ioutil.Discard, NewReader(r))
+	}
+	return err
+}
@@ -0,0 +1,118 @@
+package transfer
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSendReceive(t *testing.T) {
+	data := []byte(strings.Repeat("goftp transfer test line\n", 10000))
+	for _, codec := range []string{None, Deflate, Gzip, Zstd} {
+		for _, digest := range []string{"", "md5", "sha256"} {
+			h := &Header{Codec: codec, Digest: digest}
+			var wire bytes.Buffer
+			assert.NoError(t, Send(&wire, bytes.NewReader(data), h, -1))
+			wire.WriteString("next command")
+
+			var got bytes.Buffer
+			assert.NoError(t, Receive(&got, &wire, h), codec+" "+digest)
+			assert.Equal(t, data, got.Bytes(), codec+" "+digest)
+			assert.Equal(t, "next command", wire.String())
+		}
+	}
+}
+
+func TestReceiveChecksumMismatch(t *testing.T) {
+	h := &Header{Codec: None, Digest: "sha256"}
+	var wire bytes.Buffer
+	fw := NewWriter(&wire)
+	fw.Write([]byte("corrupted"))
+	fw.Close()
+	WriteDigest(&wire, []byte("not the digest"))
+
+	err := Receive(ioutil.Discard, &wire, h)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "checksum mismatch")
+	assert.Equal(t, 0, wire.Len())
+}
+
+func TestCheckDigest(t *testing.T) {
+	sum := []byte{0xca, 0xfe}
+	for digest, ok := range map[string]bool{
+		"cafe":                      true,
+		"caf":                       false,
+		"cafe0":                     false,
+		strings.Repeat("cafe", 1e5): false,
+	} {
+		var wire bytes.Buffer
+		fw := NewWriter(&wire)
+		fw.Write([]byte(digest))
+		fw.Close()
+		wire.WriteString("next command")
+
+		err := CheckDigest(&wire, sum)
+		assert.Equal(t, ok, err == nil, "%.10s: %v", digest, err)
+		assert.Equal(t, "next command", wire.String(), "%.10s: the digest is skipped", digest)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) { return 0, errors.New("disk error") }
+
+func TestSendAbort(t *testing.T) {
+	h := &Header{Codec: Gzip, Digest: "sha1"}
+	var wire bytes.Buffer
+	assert.Error(t, Send(&wire, io.MultiReader(strings.NewReader("abc"), failingReader{}), h, -1))
+	wire.WriteString("next command")
+
+	assert.Equal(t, ErrAborted, Receive(ioutil.Discard, &wire, h))
+	assert.Equal(t, "next command", wire.String())
+}
+
+func TestReadHeader(t *testing.T) {
+	var wire bytes.Buffer
+	assert.NoError(t, WriteHeader(&wire, &Header{Codec: Zstd, Digest: "sha256"}))
+	assert.NoError(t, WriteHeader(&wire, &Header{Error: "no such file"}))
+
+	h, err := ReadHeader(&wire)
+	assert.NoError(t, err)
+	assert.Equal(t, &Header{Codec: Zstd, Digest: "sha256"}, h)
+	h, err = ReadHeader(&wire)
+	assert.EqualError(t, err, "no such file")
+	assert.NotNil(t, h)
+}
+
+type buffer []byte
+
+func (b *buffer) WriteAt(p []byte, off int64) (int, error) {
+	if end := int(off) + len(p); end > len(*b) {
+		*b = append(*b, make([]byte, end-len(*b))...)
+	}
+	return copy((*b)[off:], p), nil
+}
+
+func TestRanges(t *testing.T) {
+	data := []byte(strings.Repeat("0123456789", 1001))
+	for _, n := range []int{1, 3, 7} {
+		h := &Header{Codec: Deflate, Digest: "sha1"}
+		ranges := Ranges(int64(len(data)), n)
+		assert.Len(t, ranges, n)
+
+		var got buffer
+		// send the ranges in reverse order to check the offsets.
+		for i := len(ranges) - 1; i >= 0; i-- {
+			var wire bytes.Buffer
+			assert.NoError(t, SendRange(&wire, bytes.NewReader(data), ranges[i][0], ranges[i][1], h, -1))
+			assert.NoError(t, ReceiveRange(&got, &wire, h))
+		}
+		assert.Equal(t, data, []byte(got))
+	}
+	assert.Equal(t, [][2]int64{{0, 0}, {0, 0}, {0, 2}}, Ranges(2, 3))
+}
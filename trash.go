@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// trashItem is the record kept next to a deleted file or directory in the trash.
+type trashItem struct {
+	ID      string    `json:"-"`
+	Path    string    `json:"path"`
+	Deleted time.Time `json:"deleted"`
+	Dir     bool      `json:"dir,omitempty"`
+	Size    int64     `json:"size"`
+	// dir is the trash the item is in.
+	dir string
+}
+
+// trashRoot is the directory the trash of every user is kept in, under Root
+// or a mount point.
+func trashRoot(root string) string {
+	return filepath.Join(root, metaDir, "trash")
+}
+
+// trashDir returns the trash of the user of s under root. Without users all
+// sessions share one trash.
+func trashDir(s *Session, root string) string {
+	user := s.User
+	if user == "" {
+		user = "anonymous"
+	}
+	return filepath.Join(trashRoot(root), user)
+}
+
+// userTrash returns the items of the trashes of the user of s, the oldest first.
+// Every mount has a trash of its own, so deleting never moves files between mounts.
+func userTrash(s *Session) ([]*trashItem, error) {
+	var items []*trashItem
+	for _, root := range metaRoots(s.FS) {
+		list, err := trashItems(s.FS, trashDir(s, root))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, list...)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+// findTrashItem returns the item id of the trashes of the user of s.
+func findTrashItem(s *Session, id string) (*trashItem, error) {
+	for _, root := range metaRoots(s.FS) {
+		if item, err := readTrashItem(s.FS, trashDir(s, root), id); err == nil {
+			return item, nil
+		}
+	}
+	return nil, errors.New("no item " + id + " in the trash")
+}
+
+// moveToTrash moves the host path name with the info fi into the trash of s.
+// Trash items are named like versions, by the time they were deleted.
+func moveToTrash(s *Session, name string, fi os.FileInfo) error {
+	dir := trashDir(s, metaRoot(s.FS, name))
+	if err := mkdirAll(s.FS, dir, 0700); err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	item := trashItem{Path: s.clientPath(name), Deleted: now, Dir: fi.IsDir()}
+	if fi.Mode().IsRegular() {
+		item.Size = fi.Size()
+	}
+	for {
+		item.ID = now.Format(versionLayout)
+		if _, err := s.FS.Lstat(filepath.Join(dir, item.ID)); os.IsNotExist(err) {
+			break
+		}
+		now = now.Add(time.Nanosecond)
+	}
+	if err := storeJSON(s.FS, filepath.Join(dir, item.ID+".json"), &item); err != nil {
+		return err
+	}
+	if err := s.FS.Rename(name, filepath.Join(dir, item.ID)); err != nil {
+		s.FS.Remove(filepath.Join(dir, item.ID+".json"))
+		return err
+	}
+	return nil
+}
+
+// storeJSON stores v as the file name through fs.
+func storeJSON(fs Driver, name string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := fs.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Abort()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	_, err = f.Commit(OverwriteReplace)
+	return err
+}
+
+// trashItems returns the items of the trash dir, the oldest first.
+func trashItems(fs Driver, dir string) ([]*trashItem, error) {
+	entries, err := fs.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	var items []*trashItem
+	for _, v := range entries {
+		id := strings.TrimSuffix(v.Name(), ".json")
+		if id == v.Name() {
+			continue
+		}
+		item, err := readTrashItem(fs, dir, id)
+		if err != nil {
+			continue
+		}
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].ID < items[j].ID })
+	return items, nil
+}
+
+// readTrashItem reads the record of the item id in the trash dir.
+func readTrashItem(fs Driver, dir, id string) (*trashItem, error) {
+	if _, err := time.Parse(versionLayout, id); err != nil {
+		return nil, errors.New("no item " + id + " in the trash")
+	}
+	f, err := fs.Open(filepath.Join(dir, id+".json"))
+	if err != nil {
+		return nil, errors.New("no item " + id + " in the trash")
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	item := &trashItem{ID: id, dir: dir}
+	if err := json.Unmarshal(data, item); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// removeItem removes the item id and its record from the trash dir for good.
+func removeItem(fs Driver, dir, id string) error {
+	if err := removeAll(fs, filepath.Join(dir, id)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return fs.Remove(filepath.Join(dir, id+".json"))
+}
+
+// purgeTrash removes the items of all trashes deleted longer than
+// option.TrashRetention before now. It returns the number of removed items.
+func purgeTrash(fs Driver, now time.Time) int {
+	removed := 0
+	for _, root := range metaRoots(fs) {
+		users, err := fs.ReadDir(trashRoot(root))
+		if err != nil {
+			continue
+		}
+		for _, u := range users {
+			dir := filepath.Join(trashRoot(root), u.Name())
+			items, _ := trashItems(fs, dir)
+			for _, item := range items {
+				if now.Sub(item.Deleted) <= option.TrashRetention {
+					break
+				}
+				if err := removeItem(fs, dir, item.ID); err != nil {
+					fmt.Println("trash purge error!", err)
+					continue
+				}
+				audit(nil, "purge", u.Name(), item.Path, item.ID)
+				removed++
+			}
+		}
+	}
+	return removed
+}
+
+// purgeTrashLoop purges the trash of the storage driver as long as the server runs.
+func purgeTrashLoop() {
+	interval := option.TrashRetention
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	for range time.Tick(interval) {
+		purgeTrash(storage, time.Now())
+	}
+}
+
+func rm(s *Session, args []string) error {
+	//rm [-r] path...
+	recursive := false
+	var rest []string
+	for _, v := range args[1:] {
+		if v == "-r" || v == "-R" {
+			recursive = true
+			continue
+		}
+		if len(v) > 1 && v[0] == '-' {
+			return errors.New("rm: unknown option " + v + "\n")
+		}
+		rest = append(rest, v)
+	}
+	if len(rest) == 0 {
+		return errors.New("rm [-r] path...\n")
+	}
+	var errs Buffer
+	for _, pattern := range rest {
+		names, err := s.expand(pattern)
+		if err != nil {
+			errs.Write([]byte(err.Error()))
+			continue
+		}
+		for _, v := range names {
+			if err := removeEntry(s, v, recursive); err != nil {
+				errs.Write([]byte(err.Error()))
+			}
+		}
+	}
+	if errs != nil {
+		return errors.New(string(errs))
+	}
+	return nil
+}
+
+// removeEntry moves the file or, if recursive, the directory name to the trash.
+func removeEntry(s *Session, name string, recursive bool) error {
+	path, err := s.resolve(name)
+	if err != nil {
+		return err
+	}
+	if path == s.Root {
+		return errors.New("cannot remove /\n")
+	}
+	unlock, err := locks.lock(s, path, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	fi, err := s.FS.Lstat(path)
+	if err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	if fi.IsDir() && !recursive {
+		return errors.New(name + " is a directory, use rm -r\n")
+	}
+	if err := check(func(h Hooks) error { return h.OnDelete(s, s.fileEvent(path, fi)) }); err != nil {
+		return err
+	}
+	if err := moveToTrash(s, path, fi); err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	notify(func(h Hooks) error { return h.OnDelete(s, s.fileEvent(path, fi)) })
+	return nil
+}
+
+func trash(s *Session, args []string) (out Buffer) {
+	//trash ls
+	//trash restore id [path]
+	//trash empty [id...]
+	if len(args) < 2 {
+		out.Write([]byte("trash ls|restore id [path]|empty [id...]\n"))
+		return
+	}
+	var err error
+	switch args[1] {
+	case "ls":
+		return trashList(s)
+	case "restore":
+		err = trashRestore(s, args[2:])
+	case "empty":
+		err = trashEmpty(s, args[2:])
+	default:
+		err = errors.New("trash ls|restore id [path]|empty [id...]\n")
+	}
+	if err != nil {
+		out.Write([]byte(err.Error()))
+	}
+	return
+}
+
+// trashList lists the trash of s, the newest item first.
+func trashList(s *Session) (out Buffer) {
+	items, err := userTrash(s)
+	if err != nil {
+		out.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	if len(items) == 0 {
+		out.Write([]byte("trash is empty\n"))
+		return
+	}
+	var buf bytes.Buffer
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		path := item.Path
+		if item.Dir {
+			path += "/"
+		}
+		fmt.Fprintf(&buf, "%s %s %12d %s\n", item.ID, item.Deleted.Local().Format("2006-01-02 15:04:05"), item.Size, path)
+	}
+	out.Write(buf.Bytes())
+	return
+}
+
+// trashRestore moves an item back to where it was deleted, or to path.
+func trashRestore(s *Session, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errors.New("trash restore id [path]\n")
+	}
+	item, err := findTrashItem(s, args[0])
+	if err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	to := item.Path
+	if len(args) == 2 {
+		to = args[1]
+	}
+	dst, err := s.resolve(to)
+	if err != nil {
+		return err
+	}
+	if _, err := s.FS.Lstat(dst); err == nil {
+		return errors.New(s.clientPath(dst) + " exists already\n")
+	}
+	event := FileEvent{Path: item.Path, To: s.clientPath(dst), Size: item.Size}
+	if err := check(func(h Hooks) error { return h.OnRename(s, event) }); err != nil {
+		return err
+	}
+	if err := mkdirAll(s.FS, filepath.Dir(dst), 0755); err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	if err := s.FS.Rename(filepath.Join(item.dir, item.ID), dst); err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	s.FS.Remove(filepath.Join(item.dir, item.ID+".json"))
+	notify(func(h Hooks) error { return h.OnRename(s, event) })
+	return nil
+}
+
+// trashEmpty removes the given items, or all of them, from the trash for good.
+func trashEmpty(s *Session, ids []string) error {
+	if len(ids) == 0 {
+		items, err := userTrash(s)
+		if err != nil {
+			return errors.New(err.Error() + "\n")
+		}
+		for _, item := range items {
+			ids = append(ids, item.ID)
+		}
+	}
+	var errs Buffer
+	for _, id := range ids {
+		item, err := findTrashItem(s, id)
+		if err != nil {
+			errs.Write([]byte(err.Error() + "\n"))
+			continue
+		}
+		if err := removeItem(s.FS, item.dir, id); err != nil {
+			errs.Write([]byte(err.Error() + "\n"))
+		}
+	}
+	if errs != nil {
+		return errors.New(string(errs))
+	}
+	return nil
+}
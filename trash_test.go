@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTrash(t *testing.T) {
+	oldRoot := Root
+	defer func() { Root = oldRoot }()
+	Root = testRoot(t, "docs/a.txt", "docs/b.txt", "logs/1.log", "logs/2.log")
+	s := &Session{Root: Root, Dir: ".", FS: localDriver{}, User: "alice"}
+	other := &Session{Root: Root, Dir: ".", FS: localDriver{}, User: "bob"}
+	exists := func(name string) bool {
+		_, err := os.Lstat(filepath.Join(Root, name))
+		return err == nil
+	}
+	ids := func() []string {
+		var list []string
+		for _, line := range strings.Split(strings.TrimSpace(string(trash(s, []string{"trash", "ls"}))), "\n") {
+			list = append(list, strings.Fields(line)[0])
+		}
+		return list
+	}
+
+	assert.Equal(t, "trash is empty\n", string(trash(s, []string{"trash", "ls"})))
+	assert.EqualError(t, rm(s, []string{"rm", "logs"}), "logs is a directory, use rm -r\n")
+	assert.EqualError(t, rm(s, []string{"rm", "-r", "/"}), "cannot remove /\n")
+	assert.NoError(t, rm(s, []string{"rm", "docs/*.txt"}))
+	assert.NoError(t, rm(s, []string{"rm", "-r", "logs"}))
+	assert.False(t, exists("docs/a.txt") || exists("docs/b.txt") || exists("logs"))
+
+	list := string(trash(s, []string{"trash", "ls"}))
+	assert.Contains(t, list, " /logs/\n")
+	assert.Contains(t, list, " /docs/a.txt\n")
+	assert.Equal(t, "trash is empty\n", string(trash(other, []string{"trash", "ls"})), "the trash is per user")
+	assert.Len(t, ids(), 3)
+
+	// the newest item is logs, restored to where it was
+	assert.Empty(t, string(trash(s, []string{"trash", "restore", ids()[0]})))
+	data, err := ioutil.ReadFile(filepath.Join(Root, "logs/2.log"))
+	assert.NoError(t, err)
+	assert.Equal(t, "logs/2.log", string(data))
+
+	// a file is never replaced, but can go elsewhere
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(Root, "docs/b.txt"), []byte("new"), 0644))
+	id := ids()[0]
+	assert.Equal(t, "/docs/b.txt exists already\n", string(trash(s, []string{"trash", "restore", id})))
+	assert.Empty(t, string(trash(s, []string{"trash", "restore", id, "old/b.txt"})))
+	assert.True(t, exists("old/b.txt"))
+
+	assert.Equal(t, "no item nope in the trash\n", string(trash(s, []string{"trash", "empty", "nope"})))
+	assert.Empty(t, string(trash(s, []string{"trash", "empty"})))
+	assert.Equal(t, "trash is empty\n", string(trash(s, []string{"trash", "ls"})))
+}
+
+func TestPurgeTrash(t *testing.T) {
+	oldRoot, oldRetention := Root, option.TrashRetention
+	defer func() { Root, option.TrashRetention = oldRoot, oldRetention }()
+	Root = testRoot(t, "a.txt", "b.txt")
+	option.TrashRetention = time.Hour
+	s := &Session{Root: Root, Dir: ".", FS: localDriver{}}
+	assert.NoError(t, rm(s, []string{"rm", "a.txt", "b.txt"}))
+
+	assert.Equal(t, 0, purgeTrash(s.FS, time.Now()))
+	assert.Equal(t, 2, purgeTrash(s.FS, time.Now().Add(2*time.Hour)))
+	entries, err := ioutil.ReadDir(trashDir(s, Root))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+// User is an account of the users file.
+type User struct {
+	Name string `json:"name"`
+	// Password is a bcrypt hash.
+	Password string `json:"password"`
+	// Root confines the user, relative to the server root. Empty means the
+	// server root.
+	Root string `json:"root,omitempty"`
+	// Admin users may run the commands which manage the server.
+	Admin    bool `json:"admin,omitempty"`
+	Disabled bool `json:"disabled,omitempty"`
+	// Allow lists the networks the user may log in from, empty means any.
+	Allow []string `json:"allow,omitempty"`
+	// Keys are the SSH public keys the user may log in with over SFTP, in
+	// authorized_keys format. A user with keys needs no password.
+	Keys []string `json:"keys,omitempty"`
+
+	allow []*net.IPNet
+	keys  []ssh.PublicKey
+}
+
+var users struct {
+	sync.RWMutex
+	byName map[string]*User
+}
+
+// dummyHash is compared against when a user does not exist, so that unknown
+// and known users take the same time to fail.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("goftp"), bcrypt.DefaultCost)
+
+// loadUsers reads the users file path, a JSON array of User.
+func loadUsers(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var list []*User
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	byName := make(map[string]*User, len(list))
+	for i, u := range list {
+		if u.Name == "" {
+			return fmt.Errorf("%s: user %d has no name", path, i)
+		}
+		if _, ok := byName[u.Name]; ok {
+			return fmt.Errorf("%s: user %s is defined twice", path, u.Name)
+		}
+		if _, err := bcrypt.Cost([]byte(u.Password)); err != nil && (u.Password != "" || len(u.Keys) == 0) {
+			return fmt.Errorf("%s: password of %s is not a bcrypt hash", path, u.Name)
+		}
+		if root := filepath.Clean(u.Root); filepath.IsAbs(u.Root) || root == ".." || strings.HasPrefix(root, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("%s: root of %s is outside the server root", path, u.Name)
+		}
+		if u.allow, err = parseNets(u.Allow); err != nil {
+			return fmt.Errorf("%s: user %s: %v", path, u.Name, err)
+		}
+		for _, v := range u.Keys {
+			key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(v))
+			if err != nil {
+				return fmt.Errorf("%s: key of %s: %v", path, u.Name, err)
+			}
+			u.keys = append(u.keys, key)
+		}
+		byName[u.Name] = u
+	}
+	users.Lock()
+	defer users.Unlock()
+	users.byName = byName
+	return nil
+}
+
+// authRequired reports whether clients have to log in.
+func authRequired() bool {
+	users.RLock()
+	defer users.RUnlock()
+	return users.byName != nil || option.Anonymous
+}
+
+// disableUser marks the user name disabled in the users file and reads the
+// file again. Other fields and users are kept as they are.
+func disableUser(name string) error {
+	path := option.Users
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var list []map[string]interface{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	found := false
+	for _, u := range list {
+		if u["name"] == name {
+			u["disabled"], found = true, true
+		}
+	}
+	if !found {
+		return errors.New("no user " + name)
+	}
+	if data, err = json.MarshalIndent(list, "", "  "); err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(data, '\n'), fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return loadUsers(path)
+}
+
+// lookupUser returns the account name, nil if there is none.
+func lookupUser(name string) *User {
+	users.RLock()
+	defer users.RUnlock()
+	return users.byName[name]
+}
+
+// authenticate returns the account name if password is right.
+func authenticate(name, password string) (*User, error) {
+	u := lookupUser(name)
+	hash := dummyHash
+	if u != nil {
+		hash = []byte(u.Password)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || u == nil {
+		return nil, errors.New("login incorrect\n")
+	}
+	if u.Disabled {
+		return nil, errors.New("login incorrect\n")
+	}
+	return u, nil
+}
+
+// loginUser remembers the name for the following pass command.
+func loginUser(s *Session, args []string) error {
+	//user name
+	if len(args) != 2 {
+		return errors.New("user name\n")
+	}
+	if !authRequired() {
+		return errors.New("no login required\n")
+	}
+	if s.User != "" {
+		return errors.New("already logged in as " + s.User + "\n")
+	}
+	s.loginName = args[1]
+	return nil
+}
+
+// loginPass logs in the user named by the preceding user command.
+func loginPass(s *Session, args []string) error {
+	//pass password
+	if len(args) != 2 {
+		return errors.New("pass password\n")
+	}
+	if s.loginName == "" {
+		return errors.New("user first\n")
+	}
+	name := s.loginName
+	s.loginName = ""
+	if err := notBanned(s); err != nil {
+		return err
+	}
+	if isAnonymousName(name) {
+		return loginAnonymous(s, args[1])
+	}
+	u, err := checkPassword(s, name, args[1])
+	if err != nil {
+		return err
+	}
+	return enter(s, name, u)
+}
+
+// notBanned fails if the client of s is banned.
+func notBanned(s *Session) error {
+	if until, ok := guard.banned(remoteIP(s)); ok {
+		return errors.New("banned until " + until.Format(time.RFC3339) + "\n")
+	}
+	return nil
+}
+
+// checkPassword authenticates name with password for the client of s. Failures
+// are audited and delayed, see loginGuard.
+func checkPassword(s *Session, name, password string) (*User, error) {
+	ip := remoteIP(s)
+	u, err := authenticate(name, password)
+	if err != nil {
+		audit(s, "login-failed", name)
+		time.Sleep(guard.failed(s, ip, name))
+		return nil, err
+	}
+	guard.succeeded(ip, name)
+	return u, nil
+}
+
+// enter starts the session of the authenticated user u in s.
+func enter(s *Session, name string, u *User) error {
+	ip := remoteIP(s)
+	if len(u.allow) > 0 && !containsIP(u.allow, net.ParseIP(ip)) {
+		audit(s, "login-denied", name)
+		return errors.New(name + " may not log in from " + ip + "\n")
+	}
+	root := Root
+	if u.Root != "" {
+		root = filepath.Join(Root, u.Root)
+	}
+	if fi, err := storage.Stat(root); err != nil || !fi.IsDir() {
+		return errors.New("home directory of " + name + " is missing\n")
+	}
+	s.mu.Lock()
+	s.User, s.account, s.Root, s.Dir = name, u, root, "."
+	s.mu.Unlock()
+	if err := check(func(h Hooks) error { return h.OnLogin(s) }); err != nil {
+		s.mu.Lock()
+		s.User, s.account = "", nil
+		s.mu.Unlock()
+		return err
+	}
+	notify(func(h Hooks) error { return h.OnLogin(s) })
+	return nil
+}
+
+// remoteIP returns the address the client of s connects from.
+func remoteIP(s *Session) string {
+	if s.Conn == nil {
+		return ""
+	}
+	host, _, err := net.SplitHostPort(s.Conn.RemoteAddr().String())
+	if err != nil {
+		return s.Conn.RemoteAddr().String()
+	}
+	return host
+}
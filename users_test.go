@@ -0,0 +1,50 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testUsers loads a users file with the given JSON, where every "HASH" is
+// replaced by the hash of "secret".
+func testUsers(t *testing.T, root, list string) error {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	assert.NoError(t, err)
+	path := filepath.Join(root, "users.json")
+	data := strings.ReplaceAll(list, "HASH", string(hash))
+	assert.NoError(t, ioutil.WriteFile(path, []byte(data), 0600))
+	t.Cleanup(func() { users.byName = nil })
+	return loadUsers(path)
+}
+
+func TestAuthenticate(t *testing.T) {
+	root := testRoot(t)
+	assert.NoError(t, testUsers(t, root, `[
+		{"name": "alice", "password": "HASH", "admin": true},
+		{"name": "bob", "password": "HASH", "disabled": true}
+	]`))
+	assert.True(t, authRequired())
+
+	u, err := authenticate("alice", "secret")
+	assert.NoError(t, err)
+	assert.True(t, u.Admin)
+	_, err = authenticate("alice", "wrong")
+	assert.EqualError(t, err, "login incorrect\n")
+	_, err = authenticate("bob", "secret")
+	assert.EqualError(t, err, "login incorrect\n")
+	_, err = authenticate("carol", "secret")
+	assert.EqualError(t, err, "login incorrect\n")
+
+	assert.EqualError(t, testUsers(t, root, `[{"name": "alice", "password": "secret"}]`),
+		filepath.Join(root, "users.json")+": password of alice is not a bcrypt hash")
+	for _, dir := range []string{"/etc", "..", "../..", "alice/../../x"} {
+		assert.EqualError(t, testUsers(t, root, `[{"name": "alice", "password": "HASH", "root": "`+dir+`"}]`),
+			filepath.Join(root, "users.json")+": root of alice is outside the server root", dir)
+	}
+	assert.NoError(t, testUsers(t, root, `[{"name": "alice", "password": "HASH", "root": "alice/../..alice"}]`))
+}
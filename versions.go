@@ -0,0 +1,219 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// versionLayout names the versions of a file by the time they were replaced,
+// the names sort in the order of the versions.
+const versionLayout = "20060102T150405.000000000Z"
+
+// versioning reports whether replaced files are kept.
+func versioning() bool {
+	return option.Versions > 0 || option.VersionAge > 0
+}
+
+// versionDir returns the directory the versions of the path name are kept in.
+func versionDir(fs Driver, name string) string {
+	root := metaRoot(fs, name)
+	rel, err := filepath.Rel(root, name)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		rel = filepath.Base(name)
+	}
+	return filepath.Join(root, metaDir, "versions", rel)
+}
+
+// commit commits the upload f of the host path name with the policy, the
+// file it replaces is kept as a version first. Anonymous uploads never replace
+// a file.
+func (s *Session) commit(f Upload, name, policy string) (string, error) {
+	if policy == OverwriteReplace && s.isAnonymous() {
+		policy = OverwriteFail
+	}
+	if policy == OverwriteReplace {
+		if err := keepVersion(s.FS, name); err != nil {
+			return "", errors.New(err.Error() + "\n")
+		}
+	}
+	return f.Commit(policy)
+}
+
+// keepVersion keeps the regular file name as its newest version and drops the
+// versions which are too many or too old. It does nothing unless versioning is on.
+func keepVersion(fs Driver, name string) error {
+	dir, err := linkVersion(fs, name)
+	if err != nil || dir == "" {
+		return err
+	}
+	return pruneVersions(fs, dir, time.Now())
+}
+
+// linkVersion links the regular file name as its newest version and returns
+// the directory of its versions, "" if no version was kept.
+func linkVersion(fs Driver, name string) (string, error) {
+	if !versioning() {
+		return "", nil
+	}
+	fi, err := fs.Lstat(name)
+	if err != nil || !fi.Mode().IsRegular() {
+		return "", nil
+	}
+	dir := versionDir(fs, name)
+	if err := mkdirAll(fs, dir, 0700); err != nil {
+		return "", err
+	}
+	// the file is linked, not moved, so it never disappears before it is replaced.
+	if err := fs.Link(name, filepath.Join(dir, time.Now().UTC().Format(versionLayout))); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// listVersions returns the versions in dir, the oldest first.
+func listVersions(fs Driver, dir string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	list := entries[:0]
+	for _, v := range entries {
+		if _, err := time.Parse(versionLayout, v.Name()); err == nil && v.Mode().IsRegular() {
+			list = append(list, v)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+// pruneVersions removes the versions in dir beyond option.Versions and those
+// replaced longer than option.VersionAge before now.
+func pruneVersions(fs Driver, dir string, now time.Time) error {
+	list, err := listVersions(fs, dir)
+	if err != nil {
+		return err
+	}
+	for i, v := range list {
+		t, _ := time.Parse(versionLayout, v.Name())
+		if (option.Versions > 0 && i < len(list)-option.Versions) ||
+			(option.VersionAge > 0 && now.Sub(t) > option.VersionAge) {
+			if err := fs.Remove(filepath.Join(dir, v.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// purgeVersions prunes the versions of every file kept by fs, so that old
+// versions go even if their file is never replaced again.
+func purgeVersions(fs Driver, now time.Time) {
+	var walk func(dir string)
+	walk = func(dir string) {
+		entries, err := fs.ReadDir(dir)
+		if err != nil {
+			return
+		}
+		for _, v := range entries {
+			if v.IsDir() {
+				walk(filepath.Join(dir, v.Name()))
+			}
+		}
+		if err := pruneVersions(fs, dir, now); err != nil {
+			fmt.Println("version purge error!", err)
+		}
+	}
+	for _, root := range metaRoots(fs) {
+		walk(filepath.Join(root, metaDir, "versions"))
+	}
+}
+
+// purgeVersionsLoop purges the versions of the storage driver as long as the server runs.
+func purgeVersionsLoop() {
+	interval := option.VersionAge
+	if interval > time.Hour {
+		interval = time.Hour
+	}
+	for range time.Tick(interval) {
+		purgeVersions(storage, time.Now())
+	}
+}
+
+// versions lists the versions of a file, the newest first.
+func versions(s *Session, args []string) (out Buffer) {
+	//versions file
+	if len(args) != 2 {
+		out.Write([]byte("usage: versions file\n"))
+		return
+	}
+	name, err := s.resolve(args[1])
+	if err != nil {
+		out.Write([]byte(err.Error()))
+		return
+	}
+	list, err := listVersions(s.FS, versionDir(s.FS, name))
+	if err != nil {
+		out.Write([]byte(err.Error() + "\n"))
+		return
+	}
+	if len(list) == 0 {
+		out.Write([]byte("no versions of " + s.clientPath(name) + "\n"))
+		return
+	}
+	for i := len(list) - 1; i >= 0; i-- {
+		v := list[i]
+		out.Write([]byte(fmt.Sprintf("%s %12d %s\n", v.Name(), v.Size(), v.ModTime().Format("2006-01-02 15:04:05"))))
+	}
+	return
+}
+
+// restore puts a version of a file back in place. The file it replaces is
+// kept as a version itself, so restore refuses to work without versioning.
+func restore(s *Session, args []string) error {
+	//restore file version
+	if len(args) != 3 {
+		return errors.New("usage: restore file version\n")
+	}
+	if !versioning() {
+		return errors.New("versioning is off, restore would lose the current file\n")
+	}
+	name, err := s.resolve(args[1])
+	if err != nil {
+		return err
+	}
+	unlock, err := locks.lock(s, name, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := time.Parse(versionLayout, args[2]); err != nil {
+		return errors.New("no version " + args[2] + " of " + s.clientPath(name) + "\n")
+	}
+	version := filepath.Join(versionDir(s.FS, name), args[2])
+	fi, err := s.FS.Lstat(version)
+	if err != nil {
+		return errors.New("no version " + args[2] + " of " + s.clientPath(name) + "\n")
+	}
+	if cur, err := s.FS.Lstat(name); err == nil && !cur.Mode().IsRegular() {
+		return errors.New(s.clientPath(name) + " is not a regular file\n")
+	}
+	if err := check(func(h Hooks) error { return h.OnUpload(s, s.fileEvent(name, fi)) }); err != nil {
+		return err
+	}
+	// the restored version must not be pruned before it is back in place.
+	if _, err := linkVersion(s.FS, name); err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	if err := s.FS.Rename(version, name); err != nil {
+		return errors.New(err.Error() + "\n")
+	}
+	pruneVersions(s.FS, versionDir(s.FS, name), time.Now())
+	audit(s, "restore", s.clientPath(name), args[2])
+	notify(func(h Hooks) error { return h.OnUpload(s, s.fileEvent(name, fi)) })
+	return nil
+}
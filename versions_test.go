@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVersions(t *testing.T) {
+	oldRoot, oldVersions := Root, option.Versions
+	defer func() { Root, option.Versions = oldRoot, oldVersions }()
+	option.Versions = 2
+
+	dedupRoot := testRoot(t)
+	dedup, err := newDedupDriver(dedupRoot, testRoot(t))
+	assert.NoError(t, err)
+	for root, fs := range map[string]Driver{testRoot(t): localDriver{}, dedupRoot: dedup} {
+		Root = root
+		s := &Session{Root: root, Dir: ".", FS: fs}
+		name := filepath.Join(root, "report.txt")
+		put := func(content string) {
+			f, err := fs.Create(name)
+			assert.NoError(t, err)
+			defer f.Abort()
+			f.Write([]byte(content))
+			_, err = s.commit(f, name, OverwriteReplace)
+			assert.NoError(t, err)
+		}
+		get := func() string {
+			f, err := fs.Open(name)
+			assert.NoError(t, err)
+			defer f.Close()
+			data, _ := ioutil.ReadAll(f)
+			return string(data)
+		}
+		ids := func() []string {
+			var list []string
+			for _, line := range strings.Split(strings.TrimSpace(string(versions(s, []string{"versions", "report.txt"}))), "\n") {
+				list = append(list, strings.Fields(line)[0])
+			}
+			return list
+		}
+
+		assert.Equal(t, "no versions of /report.txt\n", string(versions(s, []string{"versions", "report.txt"})))
+		for _, v := range []string{"one", "two", "three", "four"} {
+			put(v)
+			time.Sleep(time.Millisecond)
+		}
+		list := ids()
+		assert.Len(t, list, 2, "only the newest versions are kept")
+		assert.True(t, list[0] > list[1])
+
+		assert.NoError(t, restore(s, []string{"restore", "report.txt", list[1]}))
+		assert.Equal(t, "two", get())
+		// the replaced file became a version, the restored one is gone
+		after := ids()
+		assert.Len(t, after, 2)
+		assert.Equal(t, list[0], after[1])
+		assert.NoError(t, restore(s, []string{"restore", "report.txt", after[0]}))
+		assert.Equal(t, "four", get())
+
+		assert.Error(t, restore(s, []string{"restore", "report.txt", "20000101T000000.000000000Z"}))
+		assert.Error(t, restore(s, []string{"restore", "report.txt", "../../report.txt"}))
+
+		// the version store is out of reach of the sessions
+		_, err := s.resolve("/" + metaDir + "/versions")
+		assert.Error(t, err)
+		entries, err := readDir(fs, root)
+		assert.NoError(t, err)
+		for _, v := range entries {
+			assert.NotEqual(t, metaDir, v.Name())
+		}
+		assert.NoError(t, fs.Remove(name))
+	}
+	// the versions of the dedup driver hold on to their blobs
+	removed, err := dedup.GC()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, removed)
+}
+
+func TestPruneVersions(t *testing.T) {
+	oldRoot, oldVersions, oldAge := Root, option.Versions, option.VersionAge
+	defer func() { Root, option.Versions, option.VersionAge = oldRoot, oldVersions, oldAge }()
+	Root = testRoot(t)
+	option.Versions, option.VersionAge = 0, time.Hour
+
+	dir := versionDir(localDriver{}, filepath.Join(Root, "a.txt"))
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	var names []string
+	for _, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute, time.Minute} {
+		names = append(names, now.Add(-age).Format(versionLayout))
+	}
+	for _, v := range names {
+		assert.NoError(t, mkdirAll(localDriver{}, dir, 0700))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, v), []byte(v), 0600))
+	}
+	assert.NoError(t, pruneVersions(localDriver{}, dir, now))
+	list, err := listVersions(localDriver{}, dir)
+	assert.NoError(t, err)
+	assert.Len(t, list, 2)
+	assert.Equal(t, names[2], list[0].Name())
+
+	// the purge reaches the versions of every file
+	other := versionDir(localDriver{}, filepath.Join(Root, "docs", "b.txt"))
+	assert.NoError(t, mkdirAll(localDriver{}, other, 0700))
+	for _, v := range names {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(other, v), []byte(v), 0600))
+	}
+	purgeVersions(localDriver{}, now.Add(45*time.Minute))
+	for _, d := range []string{dir, other} {
+		list, err = listVersions(localDriver{}, d)
+		assert.NoError(t, err)
+		if assert.Len(t, list, 1, d) {
+			assert.Equal(t, names[3], list[0].Name())
+		}
+	}
+
+	// without versioning restore would lose the current file
+	option.VersionAge = 0
+	s := &Session{Root: Root, Dir: ".", FS: localDriver{}}
+	assert.EqualError(t, restore(s, []string{"restore", "docs/b.txt", names[3]}),
+		"versioning is off, restore would lose the current file\n")
+}
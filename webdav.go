@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/webdav"
+)
+
+// listenHTTP opens the listener of the HTTP gateway at addr.
+func listenHTTP(addr string) (*net.TCPListener, error) {
+	tcpAddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ListenTCP("tcp", tcpAddr)
+}
+
+// A client has httpHeaderTimeout to send the headers of a request, and a kept
+// alive connection is closed after httpIdleTimeout without one. Every
+// connection is a session, idle ones must not pile up.
+const (
+	httpHeaderTimeout = 30 * time.Second
+	httpIdleTimeout   = 2 * time.Minute
+)
+
+// serveHTTP serves the HTTP gateway until listener is closed: browsers get
+// listing pages and downloads, WebDAV clients can mount the tree. Every HTTP
+// connection is a session, its client logs in with basic authentication.
+func serveHTTP(listener *net.TCPListener) {
+	g := &httpGateway{locks: webdav.NewMemLS()}
+	server := &http.Server{
+		Handler:           g,
+		ConnContext:       g.connContext,
+		ConnState:         g.connState,
+		ReadHeaderTimeout: httpHeaderTimeout,
+		IdleTimeout:       httpIdleTimeout,
+	}
+	if err := server.Serve(listener); err != nil && !errors.Is(err, net.ErrClosed) {
+		fmt.Println(err)
+	}
+}
+
+// httpGateway is the handler of the HTTP gateway.
+type httpGateway struct {
+	// locks are the WebDAV locks, which clients take to edit files. They are
+	// advisory, transfers take the locks of the server as well.
+	locks webdav.LockSystem
+	conns sync.Map
+}
+
+// httpConn is the state of an HTTP connection. Its requests come one after
+// the other, so it needs no lock.
+type httpConn struct {
+	s *Session
+	// started tells whether the session has logged in, with the
+	// credentials auth is the hash of.
+	started bool
+	auth    [sha256.Size]byte
+}
+
+type httpConnKey struct{}
+
+func (g *httpGateway) connContext(ctx context.Context, c net.Conn) context.Context {
+	hc := &httpConn{}
+	if conn, ok := c.(*net.TCPConn); ok {
+		hc.s = newSession(conn)
+		hc.s.Protocol = "http"
+		sessions.add(hc.s)
+	}
+	g.conns.Store(c, hc)
+	return context.WithValue(ctx, httpConnKey{}, hc)
+}
+
+func (g *httpGateway) connState(c net.Conn, state http.ConnState) {
+	if state != http.StateClosed && state != http.StateHijacked {
+		return
+	}
+	if v, ok := g.conns.Load(c); ok {
+		g.conns.Delete(c)
+		if hc := v.(*httpConn); hc.s != nil {
+			sessions.remove(hc.s)
+			if hc.started {
+				disconnected(hc.s)
+			}
+		}
+	}
+}
+
+func (g *httpGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	hc, _ := r.Context().Value(httpConnKey{}).(*httpConn)
+	if hc == nil || hc.s == nil || !accepted(hc.s.Conn.RemoteAddr()) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	s, status, err := hc.login(r)
+	if err != nil {
+		if status == http.StatusUnauthorized {
+			w.Header().Set("WWW-Authenticate", `Basic realm="goftp", charset="UTF-8"`)
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	fs := &davFS{s: s, length: -1}
+	switch r.Method {
+	case http.MethodPut:
+		// a broken body must not be committed, the upload checks it.
+		fs.body = &davBody{ReadCloser: r.Body}
+		fs.length, r.Body = r.ContentLength, fs.body
+	case http.MethodGet, http.MethodHead:
+		if fi, err := fs.Stat(r.Context(), r.URL.Path); err == nil && fi.IsDir() {
+			listPage(w, r, fs)
+			return
+		}
+	case "COPY":
+		// copies would be downloads and uploads at once, cp does them.
+		http.Error(w, "COPY is not supported", http.StatusMethodNotAllowed)
+		return
+	}
+	dav := &webdav.Handler{FileSystem: fs, LockSystem: g.locks}
+	dav.ServeHTTP(w, r)
+}
+
+// login authenticates the request r like pass does. The first request of a
+// connection starts its session, later ones have to bring the same
+// credentials. It returns the HTTP status of a failure.
+func (hc *httpConn) login(r *http.Request) (*Session, int, error) {
+	name, password, ok := r.BasicAuth()
+	auth := sha256.Sum256([]byte(name + "\x00" + password))
+	if hc.started {
+		if authRequired() && (!ok || auth != hc.auth) {
+			return nil, http.StatusUnauthorized, errors.New("login incorrect")
+		}
+		return hc.s, 0, nil
+	}
+	s := hc.s
+	if err := notBanned(s); err != nil {
+		return nil, http.StatusForbidden, trimError(err)
+	}
+	var err error
+	switch {
+	case !authRequired():
+		err = check(func(h Hooks) error { return h.OnLogin(s) })
+		if err == nil {
+			notify(func(h Hooks) error { return h.OnLogin(s) })
+		}
+	case !ok:
+		return nil, http.StatusUnauthorized, errors.New("login required")
+	case isAnonymousName(name):
+		err = loginAnonymous(s, password)
+	default:
+		var u *User
+		if u, err = checkPassword(s, name, password); err == nil {
+			err = enter(s, name, u)
+		}
+	}
+	if err != nil {
+		return nil, http.StatusUnauthorized, trimError(err)
+	}
+	hc.started, hc.auth = true, auth
+	return s, 0, nil
+}
+
+// listTemplate is the page of a directory.
+var listTemplate = template.Must(template.New("list").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>{{.Path}}</title></head>
+<body>
+<h1>{{.Path}}</h1>
+<table>
+<tr><th>Name</th><th>Size</th><th>Modified</th></tr>
+{{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{end}}{{range .Entries}}<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td>{{.Size}}</td><td>{{.Modified}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// listEntry is a line of the page of a directory.
+type listEntry struct {
+	Name, Href, Size, Modified string
+}
+
+// listPage writes the page of the directory of r.
+func listPage(w http.ResponseWriter, r *http.Request, fs *davFS) {
+	// relative links need the trailing slash
+	if !strings.HasSuffix(r.URL.Path, "/") {
+		http.Redirect(w, r, r.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	name, err := fs.path(r.URL.Path)
+	var list []os.FileInfo
+	if err == nil {
+		list, err = readDir(fs.s.FS, name)
+	}
+	if err != nil {
+		status := httpStatus(err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+	data := struct {
+		Path    string
+		Entries []listEntry
+	}{Path: r.URL.Path}
+	for _, fi := range list {
+		e := listEntry{Name: fi.Name(), Href: url.PathEscape(fi.Name()), Modified: fi.ModTime().Format("2006-01-02 15:04")}
+		if fi.IsDir() {
+			e.Name += "/"
+			e.Href += "/"
+		} else {
+			e.Size = strconv.FormatInt(fi.Size(), 10)
+		}
+		data.Entries = append(data.Entries, e)
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	if err := listTemplate.Execute(w, data); err != nil {
+		fmt.Println("list page error!", err)
+	}
+}
+
+// httpStatus returns the HTTP status of a failed file operation.
+func httpStatus(err error) int {
+	switch {
+	case os.IsNotExist(err):
+		return http.StatusNotFound
+	case os.IsPermission(err):
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// davFS is the file tree of a session as a WebDAV file system. Downloads,
+// uploads, moves and deletes work like dl, ul, rename and rm.
+type davFS struct {
+	s *Session
+	// body and length are the body of a PUT and its Content-Length, -1
+	// if unknown.
+	body   *davBody
+	length int64
+}
+
+// davBody is the body of a PUT, it remembers why reading it failed.
+type davBody struct {
+	io.ReadCloser
+	err error
+}
+
+func (b *davBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if err != nil && err != io.EOF {
+		b.err = err
+	}
+	return n, err
+}
+
+// path resolves the path of a request.
+func (fs *davFS) path(p string) (string, error) {
+	name, err := fs.s.resolve(p)
+	if err != nil {
+		return "", &os.PathError{Op: "resolve", Path: p, Err: os.ErrPermission}
+	}
+	return name, nil
+}
+
+func (fs *davFS) Mkdir(ctx context.Context, p string, perm os.FileMode) error {
+	name, err := fs.path(p)
+	if err != nil {
+		return err
+	}
+	return fs.s.FS.Mkdir(name, 0755)
+}
+
+// OpenFile opens directories to list them, files to download them and, for
+// the body of a PUT, to upload them. Other opens to write, as by PROPPATCH,
+// get the file read-only.
+func (fs *davFS) OpenFile(ctx context.Context, p string, flag int, perm os.FileMode) (webdav.File, error) {
+	name, err := fs.path(p)
+	if err != nil {
+		return nil, err
+	}
+	if flag&os.O_APPEND != 0 {
+		return nil, &os.PathError{Op: "open", Path: p, Err: errors.New("append is not supported")}
+	}
+	if flag&(os.O_CREATE|os.O_TRUNC) == os.O_CREATE|os.O_TRUNC && fs.body != nil {
+		u, err := createUpload(fs.s, name)
+		if err != nil {
+			return nil, err
+		}
+		return &davUpload{fileUpload: u, body: fs.body, length: fs.length}, nil
+	}
+	fi, err := fs.s.FS.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	if fi.IsDir() {
+		return &davDir{fs: fs, name: name, fi: davInfo{fi}}, nil
+	}
+	d, err := openDownload(fs.s, name)
+	if err != nil {
+		return nil, err
+	}
+	return &davDownload{fileDownload: d}, nil
+}
+
+// RemoveAll moves p to the trash, like rm -r.
+func (fs *davFS) RemoveAll(ctx context.Context, p string) error {
+	return trimError(removeEntry(fs.s, p, true))
+}
+
+func (fs *davFS) Rename(ctx context.Context, from, to string) error {
+	src, err := fs.path(from)
+	if err != nil {
+		return err
+	}
+	dst, err := fs.path(to)
+	if err != nil {
+		return err
+	}
+	return renameEntry(fs.s, src, dst, false)
+}
+
+func (fs *davFS) Stat(ctx context.Context, p string) (os.FileInfo, error) {
+	name, err := fs.path(p)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := fs.s.FS.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+	return davInfo{fi}, nil
+}
+
+// davInfo tells the content type of a file by its extension. Otherwise
+// the WebDAV handler would open every file it lists, as a download.
+type davInfo struct {
+	os.FileInfo
+}
+
+func (fi davInfo) ContentType(ctx context.Context) (string, error) {
+	if t := mime.TypeByExtension(filepath.Ext(fi.Name())); t != "" {
+		return t, nil
+	}
+	return "application/octet-stream", nil
+}
+
+// davDir is an open directory.
+type davDir struct {
+	fs   *davFS
+	name string
+	fi   os.FileInfo
+	list []os.FileInfo
+	read bool
+}
+
+func (d *davDir) Readdir(count int) ([]os.FileInfo, error) {
+	if !d.read {
+		list, err := readDir(d.fs.s.FS, d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range list {
+			d.list = append(d.list, davInfo{fi})
+		}
+		d.read = true
+	}
+	if count <= 0 {
+		list := d.list
+		d.list = nil
+		return list, nil
+	}
+	if len(d.list) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(d.list) {
+		count = len(d.list)
+	}
+	list := d.list[:count]
+	d.list = d.list[count:]
+	return list, nil
+}
+
+func (d *davDir) Stat() (os.FileInfo, error)                   { return d.fi, nil }
+func (d *davDir) Close() error                                 { return nil }
+func (d *davDir) Read(p []byte) (int, error)                   { return 0, d.invalid("read") }
+func (d *davDir) Write(p []byte) (int, error)                  { return 0, d.invalid("write") }
+func (d *davDir) Seek(offset int64, whence int) (int64, error) { return 0, d.invalid("seek") }
+
+func (d *davDir) invalid(op string) error {
+	return &os.PathError{Op: op, Path: d.fs.s.clientPath(d.name), Err: errors.New("is a directory")}
+}
+
+// davDownload is a file opened by a GET.
+type davDownload struct {
+	*fileDownload
+}
+
+func (d *davDownload) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
+func (d *davDownload) Write(p []byte) (int, error) { return 0, errors.New("file is read-only") }
+
+// davUpload is a file opened by a PUT. It is committed only if the whole
+// body arrived and was written.
+type davUpload struct {
+	*fileUpload
+	body    *davBody
+	length  int64
+	written int64
+	err     error
+}
+
+func (u *davUpload) Write(p []byte) (int, error) {
+	n, err := u.fileUpload.Write(p)
+	u.written += int64(n)
+	if err != nil && u.err == nil {
+		u.err = err
+	}
+	return n, err
+}
+
+func (u *davUpload) Close() error {
+	err := u.err
+	if err == nil && u.body != nil {
+		err = u.body.err
+	}
+	if err == nil && u.length >= 0 && u.written != u.length {
+		err = fmt.Errorf("upload of %d bytes ended after %d", u.length, u.written)
+	}
+	if err != nil {
+		u.TransferError(err)
+	}
+	return u.fileUpload.Close()
+}
+
+func (u *davUpload) Read(p []byte) (int, error) { return 0, errors.New("file is write-only") }
+func (u *davUpload) Seek(offset int64, whence int) (int64, error) {
+	return 0, errors.New("file is write-only")
+}
+func (u *davUpload) Readdir(count int) ([]os.FileInfo, error) {
+	return nil, errors.New("not a directory")
+}
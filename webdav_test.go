@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHTTPGateway(t *testing.T) {
+	saved, oldRoot, oldStorage := *option, Root, storage
+	defer func() { *option, Root, storage = saved, oldRoot, oldStorage }()
+	option.LoginDelay = 0
+	Root = testRoot(t, "alice/docs/report.txt", "bob/secret.txt")
+	storage = localDriver{}
+	assert.NoError(t, testUsers(t, testRoot(t), `[{"name": "alice", "password": "HASH", "root": "alice"}]`))
+
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	assert.NoError(t, err)
+	defer listener.Close()
+	go serveHTTP(listener)
+	base := "http://" + listener.Addr().String()
+	do := func(method, path, password string, body string, header ...string) (*http.Response, string) {
+		req, err := http.NewRequest(method, base+path, strings.NewReader(body))
+		assert.NoError(t, err)
+		if password != "" {
+			req.SetBasicAuth("alice", password)
+		}
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		data, err := ioutil.ReadAll(resp.Body)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		return resp, string(data)
+	}
+
+	resp, _ := do("GET", "/", "", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+	assert.Contains(t, resp.Header.Get("WWW-Authenticate"), "Basic")
+	resp, _ = do("GET", "/", "wrong", "")
+	assert.Equal(t, http.StatusUnauthorized, resp.StatusCode)
+
+	// browsers get pages of the home directory, with links into it
+	resp, page := do("GET", "/", "secret", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Contains(t, page, `<a href="docs/">docs/</a>`)
+	assert.NotContains(t, page, "bob")
+	resp, page = do("GET", "/docs", "secret", "")
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "redirected to /docs/")
+	assert.Contains(t, page, `<a href="report.txt">report.txt</a>`)
+	resp, _ = do("GET", "/../bob/secret.txt", "secret", "")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, data := do("GET", "/docs/report.txt", "secret", "", "Range", "bytes=6-11")
+	assert.Equal(t, http.StatusPartialContent, resp.StatusCode)
+	assert.Equal(t, "docs/r", data)
+
+	// WebDAV changes the tree like the commands do
+	resp, _ = do("MKCOL", "/new", "secret", "")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, _ = do("PUT", "/new/a.txt", "secret", "hello")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	stored, err := ioutil.ReadFile(filepath.Join(Root, "alice/new/a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(stored))
+
+	// PROPPATCH opens the file to write, but must leave it alone
+	resp, data = do("PROPPATCH", "/new/a.txt", "secret", `<?xml version="1.0" encoding="utf-8"?>
+<D:propertyupdate xmlns:D="DAV:" xmlns:Z="urn:example"><D:set><D:prop><Z:color>red</Z:color></D:prop></D:set></D:propertyupdate>`)
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, data, "403 Forbidden")
+	stored, err = ioutil.ReadFile(filepath.Join(Root, "alice/new/a.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(stored))
+
+	// a PUT whose body breaks off is not committed
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	assert.NoError(t, err)
+	req, err := http.NewRequest("PUT", base+"/new/short.txt", nil)
+	assert.NoError(t, err)
+	req.SetBasicAuth("alice", "secret")
+	req.ContentLength = 100
+	req.Body = ioutil.NopCloser(strings.NewReader("only 20 of 100 bytes"))
+	go func() {
+		req.Write(conn)
+		conn.(*net.TCPConn).CloseWrite()
+	}()
+	// the server answers or hangs up once the handler is done
+	if resp, err := http.ReadResponse(bufio.NewReader(conn), req); err == nil {
+		assert.NotEqual(t, http.StatusCreated, resp.StatusCode)
+	}
+	conn.Close()
+	_, err = os.Stat(filepath.Join(Root, "alice/new/short.txt"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+	fs := &davFS{s: &Session{User: "alice", Root: filepath.Join(Root, "alice"), Dir: ".", FS: storage}, body: &davBody{}, length: 10}
+	f, err := fs.OpenFile(context.Background(), "/new/short.txt", os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	assert.NoError(t, err)
+	f.Write([]byte("short"))
+	assert.EqualError(t, f.Close(), "upload of 10 bytes ended after 5")
+	_, err = os.Stat(filepath.Join(Root, "alice/new/short.txt"))
+	assert.True(t, os.IsNotExist(err), "%v", err)
+
+	resp, _ = do("MOVE", "/new/a.txt", "secret", "", "Destination", base+"/new/b.txt")
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	resp, data = do("PROPFIND", "/new/", "secret", "", "Depth", "1")
+	assert.Equal(t, http.StatusMultiStatus, resp.StatusCode)
+	assert.Contains(t, data, "/new/b.txt")
+	assert.Contains(t, data, "text/plain")
+	resp, _ = do("COPY", "/new/b.txt", "secret", "", "Destination", base+"/new/c.txt")
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+	resp, _ = do("DELETE", "/new", "secret", "")
+	assert.Equal(t, http.StatusNoContent, resp.StatusCode)
+	_, err = os.Stat(filepath.Join(Root, "alice/new"))
+	assert.True(t, os.IsNotExist(err))
+	s := &Session{User: "alice", Root: filepath.Join(Root, "alice"), FS: storage}
+	assert.Contains(t, string(trash(s, []string{"trash", "ls"})), " /new/\n")
+	resp, _ = do("PROPFIND", "/"+metaDir, "secret", "", "Depth", "0")
+	assert.NotEqual(t, http.StatusMultiStatus, resp.StatusCode)
+}